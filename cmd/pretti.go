@@ -2,156 +2,6879 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"maps"
+	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 )
 
 var (
-	extList  = flag.String("ext", "", "Comma-separated file extensions to include (empty = all files)")
 	allFiles = flag.Bool("all", false, "Format all files recursively")
-	current  = flag.Bool("current", false, "Format only changed files in the current branch")
+	yes      = flag.Bool("yes", false, "Do not ask for confirmation before formatting with --all")
+
+	noConfirmIfClean   = flag.Bool("no-confirm-if-clean", false, "With --all, do not ask for confirmation when every selected file is committed, so git can undo the run")
+	warnLargeSelection = flag.Int("warn-on-large-selection", 0, "Print a warning, but go on, when more than N files are selected (0 never warns)")
+	confirmThreshold   = flag.Int("confirm-threshold", 0, "With --all, only ask for confirmation when more than N files would be formatted")
+	changedDirs        = flag.Bool("changed-dirs", false, "Format every file in the directories of the changed files, not only the changed files (default selection: --current)")
+	current            = flag.Bool("current", false, "Format files with unstaged changes (working tree vs index)")
+	manifest           = flag.String("manifest", "", `Format the files a JSON manifest lists, e.g. {"files": [{"path": "a.ts"}, {"path": "x", "parser": "json"}]}`)
+	fromFile           = flag.String("from-file", "", "Format the files listed in this file, one per line (- reads the list from stdin)")
+	respectGitignore   = flag.Bool("respect-gitignore", false, "Skip named files that .gitignore ignores (git check-ignore)")
+	pipe               = flag.Bool("pipe", false, "Format stdin with --parser or the parser for --stdin-filepath and write the result to stdout")
+	stdinFilepath      = flag.String("stdin-filepath", "", "With --pipe, the path stdin's content belongs to; prettier infers the parser from it and reads its config for it")
+	forceParser        = flag.String("force-parser", "", "Format every selected file with this prettier parser, e.g. json, whatever its extension")
+	parser             = flag.String("parser", "", "With --pipe, the prettier parser to format stdin with, e.g. typescript; overrides the one --stdin-filepath implies")
+	staged             = flag.Bool("staged", false, "Format files staged for commit (index vs HEAD)")
+	changedSource      = flag.String("changed-files-source", "", "What counts as changed: worktree, staged and untracked, combined with + or commas, e.g. worktree+staged")
+	hook               = flag.Bool("hook", false, "Pre-commit preset: implies --staged, --quiet and --quiet-prettier")
+	baseRef            = flag.String("base", "", "Format files changed since the merge base of this ref and HEAD")
+	headRev            = flag.String("head", "", "With --base and --check, check the files changed up to this commit, read from it rather than the working tree")
+	ciBase             = flag.Bool("ci-base", false, "Take --base from the CI environment (GitHub Actions, GitLab) when it is not given")
+	sinceLastRun       = flag.Bool("since-last-run", false, "Format files changed since the HEAD recorded by the last successful run")
+	firstParent        = flag.Bool("first-parent", false, "With --base, --since-tag or --since-last-run, only count commits on the first-parent line, leaving out what merges brought in")
+	stashRef           = flag.String("stash-files", "", "Format the files the stash <ref> (e.g. stash@{0}) changed or added, as they are in the working tree")
+	mergeRev           = flag.String("merge", "", "Format files the merge commit <sha> changed beyond what its parents had (its combined diff)")
+	sinceTag           = flag.String("since-tag", "", "Format files changed in commits since this tag (@latest = most recent tag)")
+	patchFile          = flag.String("patch", "", "Format the files a patch or diff file touches")
+	fromHook           = flag.String("from-hook", "", "Select files the way a git hook sees them: pre-commit or pre-push (reads the refs on stdin)")
+	strict             = flag.Bool("strict", false, "With --staged, refuse to run when a staged file also has unstaged changes")
+	abortOnConflict    = flag.Bool("abort-on-conflict-markers", false, "Stop instead of skipping selected files that still have merge conflict markers (also with --strict)")
+	requireClean       = flag.Bool("require-clean-worktree", false, "Refuse to write files when git status shows changes to files outside the selection")
+	onlyStagedHunks    = flag.Bool("only-staged-hunks", false, "Format the staged content of each staged file and restage it, leaving unstaged edits untouched (implies --staged)")
+
+	extList              = flag.String("ext", "", "Comma-separated file extensions to include (empty = all files)")
+	langList             = flag.String("lang", "", "Comma-separated languages to include, e.g. typescript,json")
+	autoSelectExt        = flag.Bool("auto-select-ext", false, "Use the extensions of the selected files that the installed prettier supports instead of a fixed list")
+	autoExt              = flag.Bool("auto-ext", false, "Default to every extension the installed prettier supports instead of the built-in list")
+	strictExt            = flag.Bool("strict-ext", false, "Fail if an --ext extension is not supported by the installed prettier, and apply the extension filter to named files too")
+	extFromGitattributes = flag.Bool("ext-from-gitattributes", false, "Also format files whose linguist-language in .gitattributes is a language prettier supports")
+	excludeList          = flag.String("exclude", "", "Comma-separated glob patterns of paths to skip")
+	resolveSymlinks      = flag.Bool("resolve-symlinks", false, "Follow symlinks and format their targets inside the repository, each once, instead of skipping them")
+	noDefaultExcludes    = flag.Bool("no-default-excludes", false, "Do not skip node_modules, dist, build, coverage, .next and vendor")
+	rootDir              = flag.String("root", "", "Treat <dir> as the repository root: git commands run there and relative paths resolve against it (command line only)")
+	noGit                = flag.Bool("no-git", false, "With --root, accept a directory that is not a git repository; only --all and named files work there")
+	noIgnore             = flag.Bool("no-ignore", false, "Do not skip files listed in .gitignore or .prettierignore")
+	maxDepth             = flag.Int("max-depth", -1, "With --all, do not descend more than N directories below the root (-1 = unlimited)")
+	includeNestedRepos   = flag.Bool("include-nested-repos", false, "With --all, also walk into directories that are git repositories of their own")
+	skipEmptyFiles       = flag.Bool("skip-empty-files", true, "Skip zero-length files, which some prettier plugins fail on")
+	formatEmptyFiles     = flag.Bool("format-empty-files", false, "Format zero-length files too (same as --skip-empty-files=false)")
+	codeowners           = flag.String("codeowners", "", "Only format files CODEOWNERS assigns to this user or team, e.g. @org/web")
+	contentMatch         = flag.String("content-match", "", "Only format files whose content matches this regular expression, e.g. '@format'")
+	modifiedAfter        = flag.String("modified-after", "", "Skip files last modified before this duration ago (e.g. 720h) or date (YYYY-MM-DD)")
+
+	check              = flag.Bool("check", false, "Report files that need formatting without writing them")
+	changedLinesOnly   = flag.Bool("check-only-changed-lines", false, "With --check, only report files whose changed lines need formatting")
+	noWrite            = flag.Bool("no-write", false, "Alias for --check")
+	reportFormat       = flag.String("report-format", "text", "Report format: text, json, sarif (requires --check), github or junit")
+	sarif              = flag.Bool("sarif", false, "Same as --report-format sarif")
+	jsonOut            = flag.Bool("json", false, "Same as --report-format json")
+	emitEvents         = flag.String("emit-events", "", "Write run-start, file-start, file-done and run-end events as JSON lines to this file, or fd:N")
+	jsonLines          = flag.Bool("json-lines", false, "Stream one JSON object per file to stdout as prettier writes it")
+	countByStatus      = flag.Bool("count-by-status", false, "End with a line like pretti: formatted=12 unchanged=30 errored=0 skipped=3 exit=0 for CI logs to grep")
+	collapseOutput     = flag.Bool("collapse-output", false, "Print the result as one line, e.g. formatted=12 unchanged=30 errored=0 skipped=3, and hide prettier's output")
+	print0             = flag.Bool("print0", false, "Print only the changed (or with --check, unformatted) files, each followed by a NUL byte; implies --quiet-prettier")
+	relativeTo         = flag.String("relative-to", "", "Print the paths in the text report, --print0 and list relative to this directory")
+	limitOutputLines   = flag.Int("limit-output-lines", 0, "List at most N files in the text summary, then how many more there are (0 = no limit)")
+	groupByDir         = flag.Bool("group-by-dir", false, "Group the files in the text summary by directory")
+	reportConfigSource = flag.Bool("report-config-source", false, "Print every option's value and whether it came from the command line, the environment, a config file or the default, then exit")
+	dumpSelection      = flag.Bool("dump-selection-tree", false, "Print every candidate file to stderr as kept or dropped, with the filter that dropped it")
+	printIgnored       = flag.Bool("print-skipped-ignored", false, "List the files skipped because an ignore file matched them, with the file that did")
+	reportSkipped      = flag.Bool("report-skipped-reasons", false, "Report how many candidate files were skipped, by reason")
+	outputFile         = flag.String("output-file", "", "Write the report to this file instead of stdout")
+	formatModifiedOnly = flag.Bool("format-modified-only", false, "Check first and only write the files prettier would change")
+	formatThenCheck    = flag.Bool("format-then-check", false, "After writing, check the files again and fail if any still need formatting")
+	backupDir          = flag.String("backup", "", "Copy each file's original content into this directory before writing it")
+	normalizeEOL       = flag.String("normalize-line-endings", "", "Convert line endings to lf, crlf or auto (the .gitattributes eol, else the repository's usual style) before formatting")
+	diffTool           = flag.String("diff-tool", "", "Open each file prettier would change in this diff tool next to its formatted version, writing nothing; git uses git config diff.tool")
+	previewDir         = flag.String("preview-dir", "", "Write the formatted files into this directory instead of over the originals")
+	dryRun             = flag.Bool("dry-run", false, "Print the prettier command that would run without running it")
+	countOnly          = flag.Bool("count-only", false, "Print only the number of files that would be formatted and exit")
+	emptyExitCode      = flag.Int("empty-exit-code", 0, "Exit code to use when the selection matches no files")
+	dryRunCheck        = flag.Bool("dry-run-check", false, "With --dry-run, also check the files and exit 1 if any would change")
+	reportDiffStats    = flag.Bool("report-diff-stats", false, "With --check, report how many lines prettier would add and remove in each file that needs formatting")
+	diffStatOnly       = flag.Bool("stat", false, "With --dry-run, report how many files and lines prettier would change")
+	statsJSON          = flag.Bool("stats-json", false, "Print per-phase timing as JSON to stderr when the run finishes")
+	profile            = flag.String("profile", "", "Write a pprof CPU profile of the run to this file")
+	quiet              = flag.Bool("quiet", false, "Print nothing of pretti's own on success; show it only when the run fails")
+	summaryOnChange    = flag.Bool("summary-only-on-change", false, "Print nothing unless a file's content changed; implies --quiet-prettier")
+	quietPrettier      = flag.Bool("quiet-prettier", false, "Hide prettier's own output unless prettier fails")
+	verbose            = flag.Bool("verbose", false, "Print extra detail, including how long each prettier invocation took")
+	slowThreshold      = flag.Duration("slow-threshold", 10*time.Second, "With --verbose, flag prettier invocations that take longer than this")
+	jobs               = flag.Int("jobs", runtime.NumCPU(), "Number of parallel workers")
+	maxOpenFiles       = flag.Int("max-open-files", 64, "Most files the content filters (conflict markers, --content-match) read at the same time")
+	walkConcurrency    = flag.Int("walk-concurrency", 2*runtime.NumCPU(), "Number of directories --all walks in parallel, separate from the --jobs prettier runs")
+	progressMode       = flag.String("progress", "", "Show progress on stderr as prettier batches finish, counted in files or bytes; bytes implies --schedule largest-first")
+	schedule           = flag.String("schedule", "selection", "Order files are handed to prettier in: selection, or largest-first to start on the biggest files")
+	batchSize          = flag.Int("batch-size", 0, "Pass at most N files to each prettier invocation instead of choosing a batch size from --jobs (0 = automatic)")
+	maxMemoryMB        = flag.Int("max-memory-mb", 0, "Run fewer parallel prettier processes if --jobs of them would need more than this many MB (0 = no limit)")
+	failFast           = flag.Bool("fail-fast", false, "Stop at the first failing prettier batch instead of running the rest")
+	perFileTimeout     = flag.Duration("per-file-timeout", 0, "Run prettier once per file and kill any run that takes longer than this, e.g. 30s (0 = no limit)")
+	resumeJournalOn    = flag.Bool("resume-journal", false, "Record each finished batch of a write run in .git/pretti-resume, for --resume after an interruption")
+	resume             = flag.Bool("resume", false, "Skip the files an interrupted --resume-journal run with the same arguments already formatted; implies --resume-journal")
+	timeBudget         = flag.Duration("time-budget", 0, "Stop starting prettier batches once this long has passed since pretti started, e.g. 10m (0 = no limit)")
+	maxErrors          = flag.Int("max-errors", 0, "Stop once prettier has reported errors for this many files (0 = no limit)")
+
+	prettierPath          = flag.String("prettier-path", "", "Run this prettier executable instead of the one found in PATH")
+	runner                = flag.String("runner", "", "How to launch prettier: direct, npx, pnpm, yarn or bunx, or a comma-separated list to try in order")
+	requireVersion        = flag.String("require-prettier-version", "", "Refuse to run unless prettier's version matches, e.g. 3.3.3, ^3.3.0 or ~3.3.0")
+	writeVersionsLock     = flag.Bool("write-versions-lock", false, "After a successful run, record the prettier and plugin versions and a prettier config hash in .pretti.lock")
+	verifyVersionsLock    = flag.Bool("verify-versions-lock", false, "Refuse to run unless prettier, its plugins and its config match .pretti.lock")
+	prettierConfig        = flag.String("config", "", "Prettier config file to use (passed as --config; defaults to $PRETTIER_CONFIG)")
+	prettierOpts          = listFlag("opt", "Prettier option as key=value, e.g. tabWidth=4 (repeatable)")
+	plugins               = listFlag("plugin", "Prettier plugin package or path, passed as --plugin (repeatable)")
+	autoPlugins           = flag.Bool("auto-plugins", false, "Retry files prettier cannot parse once with their framework's plugin, if it is installed")
+	commandTemplate       = flag.String("command-template", "", "Command to write files with instead of prettier --write, e.g. \"{bin} --write {args} {files}\" (shell-style quoting)")
+	postFormatCommand     = flag.String("post-format-command", "", "Command to run once after a successful write run, with the formatted files in $PRETTI_FORMATTED (shell-style quoting)")
+	prettierExtraArgs     = flag.String("prettier-args", "", "Extra arguments for prettier, inserted before the file list (shell-style quoting)")
+	noPrettierColor       = flag.Bool("no-prettier-color", false, "Pass --no-color to prettier even when stdout is a terminal")
+	prettierCache         = flag.Bool("prettier-cache", false, "Pass --cache to prettier so it skips files unchanged since its last run")
+	prettierCacheLocation = flag.String("prettier-cache-location", "", "With --prettier-cache, path of prettier's cache file (passed as --cache-location; default .git/prettier-cache)")
+	requirePragma         = flag.Bool("require-pragma", false, "Pass --require-pragma to prettier so it only formats files with a @format or @prettier pragma")
+	insertPragma          = flag.Bool("insert-pragma", false, "Pass --insert-pragma to prettier so it adds a @format pragma to the files it formats")
 )
 
-func main() {
-	flag.Parse()
+func main() {
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "help":
+		printHelp()
+		return
+	case "completion":
+		if err := writeCompletion(os.Stdout, flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "update-check":
+		if err := updateCheck(os.Stdout); err != nil {
+			log.Fatalf("Error checking for updates: %v", err)
+		}
+		return
+	case "doctor":
+		flag.CommandLine.Parse(flag.Args()[1:])
+		os.Exit(doctor(os.Stdout))
+	case "validate-config":
+		flag.CommandLine.Parse(flag.Args()[1:])
+		os.Exit(validateConfig(os.Stdout))
+	case "changed", "staged", "all", "list":
+		// Flags may also follow the subcommand.
+		command := flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
+		switch command {
+		case "changed":
+			*current = true
+		case "staged":
+			*staged = true
+		case "all":
+			*allFiles = true
+		case "list":
+			listOnly = true
+		}
+		parseFileArgs(flag.Args())
+	default:
+		parseFileArgs(flag.Args())
+	}
+
+	stopProfile, err := startProfile(*profile)
+	if err != nil {
+		log.Fatalf("Error starting the CPU profile: %v", err)
+	}
+	code := run()
+	stopProfile()
+	if *statsJSON {
+		if err := writeStats(os.Stderr); err != nil {
+			log.Fatalf("Error writing stats: %v", err)
+		}
+	}
+	os.Exit(code)
+}
+
+// startProfile starts the --profile CPU profile, if one was asked for, and
+// returns the function that stops it and closes the file. run leaves through
+// log.Fatal in many places, which exits without running deferred calls, so
+// until then the standard logger stops the profile too: everything it writes
+// is fatal, prettierFailed having a logger of its own.
+func startProfile(path string) (func(), error) {
+	if path == "" {
+		return func() {}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+	log.SetOutput(stopWriter{os.Stderr, stop})
+	return func() {
+		log.SetOutput(os.Stderr)
+		stop()
+	}, nil
+}
+
+// stopWriter calls stop after each write, for startProfile.
+type stopWriter struct {
+	io.Writer
+	stop func()
+}
+
+func (w stopWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.stop()
+	return n, err
+}
+
+// isGlob reports whether a positional argument is a glob pattern rather than
+// a literal path.
+func isGlob(arg string) bool {
+	return strings.ContainsAny(arg, "*?[")
+}
+
+// expandGlob returns the files matching pattern, in which ** matches any
+// number of directories and the other syntax is that of path.Match. The walk
+// starts at the pattern's literal leading directories and skips .git and
+// excluded directories.
+func expandGlob(pattern string) ([]string, error) {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	literal := 0
+	for literal < len(segments)-1 && !isGlob(segments[literal]) {
+		literal++
+	}
+	base := strings.Join(segments[:literal], "/")
+	if base == "" && literal > 0 {
+		base = "/"
+	} else if base == "" {
+		base = "."
+	}
+	segments = segments[literal:]
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	patterns := excludePatterns()
+	var matches []string
+	err := filepath.WalkDir(filepath.FromSlash(base), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := relPath(base, p)
+		if d.IsDir() {
+			if p != filepath.FromSlash(base) && (d.Name() == ".git" || isExcluded(rel, patterns)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if matchSegments(segments, strings.Split(rel, "/")) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return matches, err
+}
+
+// matchSegments matches a path, split into its elements, against a pattern
+// split the same way, where a ** element matches zero or more elements.
+func matchSegments(pattern, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(elems); i++ {
+			if matchSegments(pattern[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], elems[0])
+	return ok && matchSegments(pattern[1:], elems[1:])
+}
+
+// holdStdout redirects pretti's stdout into a buffer for --quiet. The
+// returned function restores stdout and, when show is true, prints what was
+// held back.
+func holdStdout() (release func(show bool)) {
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		return func(bool) {}
+	}
+	os.Stdout = w
+
+	var held bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&held, r)
+		close(done)
+	}()
+	return func(show bool) {
+		w.Close()
+		<-done
+		r.Close()
+		os.Stdout = stdout
+		if show {
+			stdout.Write(held.Bytes())
+		}
+	}
+}
+
+// version is pretti's release version, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// latestReleaseURL is the GitHub API endpoint for pretti's newest release.
+const latestReleaseURL = "https://api.github.com/repos/karthikeyaspace/pretti/releases/latest"
+
+// updateCheck looks up the latest release on GitHub and tells w whether it
+// is newer than this build. It never downloads anything.
+func updateCheck(w io.Writer) error {
+	req, err := http.NewRequest(http.MethodGet, latestReleaseURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub returned %s", resp.Status)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return fmt.Errorf("parsing the GitHub response: %w", err)
+	}
+
+	current, _, err := parseVersion(version)
+	if err != nil {
+		_, err = fmt.Fprintf(w, "This is a development build (%s). The latest release is %s:\n  %s\n", version, release.TagName, release.HTMLURL)
+		return err
+	}
+	latest, _, err := parseVersion(release.TagName)
+	if err != nil {
+		return fmt.Errorf("latest release tag %q: %w", release.TagName, err)
+	}
+	if slices.Compare(latest[:], current[:]) > 0 {
+		_, err = fmt.Fprintf(w, "pretti %s is available (you have %s):\n  %s\n", release.TagName, version, release.HTMLURL)
+	} else {
+		_, err = fmt.Fprintf(w, "pretti %s is up to date\n", version)
+	}
+	return err
+}
+
+// doctor runs the doctor subcommand: it checks that git, the repository,
+// the config files, the runner and prettier all resolve the way a run would
+// see them, printing one ok or FAIL line per check to w. It returns 1 if any
+// check failed.
+func doctor(w io.Writer) int {
+	code := 0
+	report := func(name, detail string, err error) bool {
+		if err != nil {
+			fmt.Fprintf(w, "FAIL  %s: %v\n", name, err)
+			code = 1
+		} else {
+			fmt.Fprintf(w, "ok    %s: %s\n", name, detail)
+		}
+		return err == nil
+	}
+
+	if *rootDir != "" && !report("root", *rootDir, enterRoot(*rootDir)) {
+		return code
+	}
+	out, err := exec.Command("git", "--version").Output()
+	report("git", strings.TrimSpace(string(out)), err)
+	if *noGit {
+		fmt.Fprintln(w, "skip  repository: --no-git is set")
+	} else {
+		gitRoot, err := getGitRoot()
+		report("repository", gitRoot, err)
+	}
+
+	if err := loadConfig(); err != nil {
+		report("config", "", err)
+	} else {
+		var found []string
+		for _, path := range []string{globalConfigPath(), projectConfigPath()} {
+			if _, err := os.Stat(path); err == nil {
+				found = append(found, path)
+			}
+		}
+		detail := "no config files"
+		if len(found) > 0 {
+			detail = strings.Join(found, ", ")
+		}
+		report("config", detail, nil)
+	}
+
+	if *prettierPath != "" {
+		report("prettier-path", *prettierPath, checkExecutable(*prettierPath))
+	}
+	runnerName := "direct"
+	if *runner != "" {
+		var err error
+		if runnerName, err = resolveRunner(*runner); err != nil {
+			report("runner", "", err)
+			return code
+		}
+	}
+	report("runner", fmt.Sprintf("%s (%s)", runnerName, strings.Join(prettierCmdline(), " ")), nil)
+
+	out, err = prettierCommand(context.Background(), "--version").Output()
+	if err != nil {
+		report("prettier", "", fmt.Errorf("failed to run prettier --version: %w", err))
+		return code
+	}
+	version := strings.TrimSpace(string(out))
+	if *requireVersion != "" {
+		if ok, err := versionSatisfies(version, *requireVersion); err != nil || !ok {
+			if err == nil {
+				err = fmt.Errorf("%s does not satisfy the required version %s", version, *requireVersion)
+			}
+			report("prettier", "", err)
+			return code
+		}
+	}
+	report("prettier", version, nil)
+	return code
+}
+
+// listOnly is set by the list subcommand: print the selection and exit.
+var listOnly bool
+
+// diffRevs are the git diff arguments the selection was made with, or nil
+// when it did not come from git diff.
+var diffRevs []string
+
+// fileArgs are the files named on the command line. When there are any they
+// are the selection, and git is not consulted.
+var fileArgs []string
+
+// parseFileArgs collects the positional arguments in args as fileArgs. The
+// flag package stops at the first non-flag argument, so flags that follow a
+// file name are parsed here as well.
+func parseFileArgs(args []string) {
+	for len(args) > 0 {
+		fileArgs = append(fileArgs, args[0])
+		flag.CommandLine.Parse(args[1:])
+		args = flag.Args()
+	}
+}
+
+// run performs a single pretti invocation and returns the process exit code.
+func run() (code int) {
+	if *rootDir != "" {
+		if err := enterRoot(*rootDir); err != nil {
+			log.Fatalf("Invalid --root: %v", err)
+		}
+	}
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Error reading config: %v", err)
+	}
+	if *reportConfigSource {
+		if err := writeConfigSources(os.Stdout); err != nil {
+			log.Fatalf("Error writing config sources: %v", err)
+		}
+		return 0
+	}
+	if *hook {
+		*staged = true
+		*quiet = true
+		*quietPrettier = true
+	}
+	if *collapseOutput || *summaryOnChange || *print0 {
+		*quietPrettier = true
+	}
+	// The summary to count for --count-by-status. It stays nil for runs
+	// that end before any file is processed, which count as zeros. A run
+	// that only prints the help has nothing to count.
+	var summary *Result
+	var showedHelp bool
+	if *countByStatus {
+		// Deferred before --quiet's release so the line comes last.
+		defer func() {
+			if showedHelp {
+				return
+			}
+			w := os.Stdout
+			if machineReport() {
+				w = os.Stderr
+			}
+			if err := writeStatusLine(w, summary, code); err != nil {
+				log.Fatalf("Error writing status line: %v", err)
+			}
+		}()
+	}
+	if *quiet {
+		release := holdStdout()
+		defer func() { release(code != 0) }()
+	}
+
+	if *prettierPath != "" {
+		if err := checkExecutable(*prettierPath); err != nil {
+			log.Fatalf("Invalid --prettier-path: %v", err)
+		}
+	}
+
+	if *runner != "" {
+		name, err := resolveRunner(*runner)
+		if err != nil {
+			log.Fatalf("Invalid --runner: %v", err)
+		}
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "Using runner %s: %s\n", name, strings.Join(prettierCmdline(), " "))
+		}
+	}
+
+	if *requireVersion != "" {
+		if err := checkPrettierVersion(*requireVersion); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	if *verifyVersionsLock {
+		if err := verifyLock(); err != nil {
+			log.Fatalf("Refusing to format: %v", err)
+		}
+	}
+
+	if path := prettierConfigPath(); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			log.Fatalf("Invalid prettier config: %v", err)
+		}
+	}
+
+	var err error
+	if optArgs, err = translateOpts(*prettierOpts); err != nil {
+		log.Fatalf("Invalid --opt: %v", err)
+	}
+	if extraArgs, err = splitArgs(*prettierExtraArgs); err != nil {
+		log.Fatalf("Invalid --prettier-args: %v", err)
+	}
+	if templateArgs, err = parseTemplate(*commandTemplate); err != nil {
+		log.Fatalf("Invalid --command-template: %v", err)
+	}
+	if postFormatArgs, err = splitArgs(*postFormatCommand); err != nil {
+		log.Fatalf("Invalid --post-format-command: %v", err)
+	}
+	if diffToolArgs, err = resolveDiffTool(*diffTool); err != nil {
+		log.Fatalf("Invalid --diff-tool: %v", err)
+	}
+	if diffToolArgs != nil && (checkMode() || *onlyStagedHunks || *formatThenCheck || *jsonLines || *reportFormat != "text" || *previewDir != "") {
+		log.Fatal("--diff-tool cannot be used with --check, --only-staged-hunks, --format-then-check, --json-lines, --report-format or --preview-dir")
+	}
+	if !*prettierCache && *prettierCacheLocation != "" {
+		fmt.Fprintln(os.Stderr, "Warning: --prettier-cache-location has no effect without --prettier-cache")
+	} else if *prettierCache && *prettierCacheLocation == "" {
+		// Keep the cache out of the working tree. Outside a repository
+		// prettier's own default applies.
+		if gitDir, err := getGitDir(); err == nil {
+			*prettierCacheLocation = filepath.Join(gitDir, "prettier-cache")
+		}
+	}
+	for _, plugin := range *plugins {
+		if isPluginPath(plugin) {
+			if _, err := os.Stat(plugin); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: plugin %s: %v\n", plugin, err)
+			}
+		}
+	}
+
+	if *pipe {
+		if *parser == "" && *stdinFilepath == "" {
+			log.Fatal("--pipe needs --parser or --stdin-filepath: there is no file name to infer the language from")
+		}
+		if len(fileArgs) > 0 || *fromFile != "" || *manifest != "" || *allFiles || checkMode() || *dryRun {
+			log.Fatal("--pipe reads stdin, so it cannot be used with files, --all, --check or --dry-run")
+		}
+		if err := formatPipe(); err != nil {
+			return prettierFailed("Error formatting stdin", err)
+		}
+		return 0
+	}
+	if *parser != "" {
+		log.Fatal("--parser can only be used with --pipe")
+	}
+	if *stdinFilepath != "" {
+		log.Fatal("--stdin-filepath can only be used with --pipe")
+	}
+	if *forceParser != "" {
+		fmt.Fprintf(os.Stderr, "Warning: --force-parser %s applies to every selected file; narrow the selection with --ext or --exclude\n", *forceParser)
+	}
+
+	if *changedLinesOnly && !checkMode() {
+		log.Fatal("--check-only-changed-lines requires --check")
+	}
+	if *headRev != "" && (!checkMode() || *changedLinesOnly) {
+		log.Fatal("--head reads files from a commit, so it only works with --check, and not with --check-only-changed-lines")
+	}
+	if *formatThenCheck && (checkMode() || *onlyStagedHunks) {
+		log.Fatal("--format-then-check cannot be used with --check or --only-staged-hunks")
+	}
+	if *sarif && *jsonOut {
+		log.Fatal("--sarif and --json cannot be used together")
+	}
+	if *sarif || *jsonOut {
+		if *reportFormat != "text" {
+			log.Fatal("--sarif and --json cannot be used with --report-format")
+		}
+		*reportFormat = "json"
+		if *sarif {
+			*reportFormat = "sarif"
+		}
+	}
+	if *reportFormat == "text" && !flagWasSet("report-format") && checkMode() && os.Getenv("GITHUB_ACTIONS") == "true" {
+		// Annotations put check failures on the pull request's diff.
+		*reportFormat = "github"
+	}
+	if !slices.Contains(reportFormats, *reportFormat) {
+		log.Fatalf("Unknown --report-format %q: use %s", *reportFormat, strings.Join(reportFormats, ", "))
+	}
+	if *reportFormat == "sarif" && !checkMode() {
+		log.Fatal("--report-format sarif can only be used with --check or --no-write")
+	}
+	if *previewDir != "" && (checkMode() || *onlyStagedHunks || *formatThenCheck || *jsonLines || *reportFormat != "text") {
+		log.Fatal("--preview-dir cannot be used with --check, --only-staged-hunks, --format-then-check, --json-lines or --report-format")
+	}
+	switch *normalizeEOL {
+	case "", "lf", "crlf", "auto":
+	default:
+		log.Fatalf("Unknown --normalize-line-endings %q: use lf, crlf or auto", *normalizeEOL)
+	}
+	if *normalizeEOL != "" && (checkMode() || *onlyStagedHunks || *previewDir != "") {
+		log.Fatal("--normalize-line-endings rewrites files, so it cannot be used with --check, --only-staged-hunks or --preview-dir")
+	}
+	if *summaryOnChange && (checkMode() || machineReport() || *jsonLines) {
+		log.Fatal("--summary-only-on-change is for writing files with a text report; it cannot be used with --check, --json-lines or --report-format json, sarif or junit")
+	}
+	if *print0 && (machineReport() || *jsonLines || *collapseOutput || *groupByDir) {
+		log.Fatal("--print0 prints a bare file list; it cannot be used with --report-format json, sarif or junit, --json-lines, --collapse-output or --group-by-dir")
+	}
+	if *relativeTo != "" {
+		abs, err := filepath.Abs(*relativeTo)
+		if err != nil {
+			log.Fatalf("Invalid --relative-to: %v", err)
+		}
+		*relativeTo = abs
+	}
+	if *collapseOutput && (machineReport() || *jsonLines) {
+		log.Fatal("--collapse-output is a compact text report; it cannot be used with --report-format json, sarif or junit, or --json-lines")
+	}
+	if *diffStatOnly && !*dryRun {
+		log.Fatal("--stat can only be used with --dry-run")
+	}
+	if *dryRunCheck && !*dryRun {
+		log.Fatal("--dry-run-check can only be used with --dry-run")
+	}
+	if *reportDiffStats && (!checkMode() || *onlyStagedHunks || *headRev != "") {
+		// The stats compare prettier's output with the file on disk.
+		log.Fatal("--report-diff-stats can only be used with --check, and not with --only-staged-hunks or --head")
+	}
+	if *dryRun && *diffStatOnly && *reportFormat == "json" {
+		log.Fatal("--stat prints a text summary; it cannot be used with --report-format json")
+	}
+	if *jsonLines && (checkMode() || *reportFormat != "text" || *onlyStagedHunks) {
+		log.Fatal("--json-lines can only be used when writing files, and not with --report-format or --only-staged-hunks")
+	}
+	if templateArgs != nil && (checkMode() || *onlyStagedHunks || *previewDir != "" || *jsonLines) {
+		log.Fatal("--command-template replaces prettier --write, so it cannot be used with --check, --only-staged-hunks, --preview-dir or --json-lines")
+	}
+
+	if *extList, err = expandExtAliases(*extList); err != nil {
+		log.Fatalf("Invalid --ext: %v", err)
+	}
+	if *autoSelectExt && (*extList != "" || *langList != "") {
+		log.Fatal("--auto-select-ext picks the extensions itself, so it cannot be used with --ext or --lang")
+	}
+	extensions, err := resolveExtensions()
+	if err != nil {
+		log.Fatalf("Error resolving extensions: %v", err)
+	}
+	if *strictExt {
+		if err := checkExtensions(strings.Split(*extList, ",")); err != nil {
+			log.Fatalf("Invalid --ext: %v", err)
+		}
+	}
+	if *jobs < 1 {
+		log.Fatal("--jobs must be at least 1")
+	}
+	if *walkConcurrency < 1 {
+		log.Fatal("--walk-concurrency must be at least 1")
+	}
+	if *maxOpenFiles < 1 {
+		log.Fatal("--max-open-files must be at least 1")
+	}
+	openFileSlots = make(chan struct{}, *maxOpenFiles)
+	if *emitEvents != "" {
+		var err error
+		if events, err = openEvents(*emitEvents); err != nil {
+			log.Fatalf("Invalid --emit-events: %v", err)
+		}
+	}
+	switch *progressMode {
+	case "", "files":
+	case "bytes":
+		// A few huge files are where counting bytes helps, and largest-first is
+		// what keeps them from straggling at the end.
+		if !flagWasSet("schedule") {
+			*schedule = "largest-first"
+		}
+	default:
+		log.Fatalf("Unknown --progress %q: use files or bytes", *progressMode)
+	}
+	switch *schedule {
+	case "selection", "largest-first":
+	default:
+		log.Fatalf("Unknown --schedule %q: use selection or largest-first", *schedule)
+	}
+	if *batchSize < 0 || *batchSize == 0 && flagWasSet("batch-size") {
+		log.Fatal("--batch-size must be at least 1")
+	}
+	if *batchSize > 1 && *perFileTimeout > 0 {
+		log.Fatal("--per-file-timeout runs prettier once per file; it cannot be used with --batch-size")
+	}
+	if *limitOutputLines < 0 {
+		log.Fatal("--limit-output-lines must not be negative")
+	}
+	if *warnLargeSelection < 0 {
+		log.Fatal("--warn-on-large-selection must not be negative")
+	}
+	if *maxMemoryMB > 0 {
+		if limited, budget := memoryLimitedJobs(*jobs, *maxMemoryMB); limited < *jobs {
+			fmt.Fprintf(os.Stderr, "Running %d prettier processes at a time instead of %d: about %d MB is free for them (--max-memory-mb)\n", limited, *jobs, budget)
+			*jobs = limited
+		}
+	}
+	if *contentMatch != "" {
+		if *headRev != "" {
+			log.Fatal("--content-match reads the working tree, so it cannot be used with --head")
+		}
+		if contentPattern, err = regexp.Compile(*contentMatch); err != nil {
+			log.Fatalf("Invalid --content-match: %v", err)
+		}
+	}
+	if *modifiedAfter != "" {
+		modifiedCutoff, err = parseCutoff(*modifiedAfter, time.Now())
+		if err != nil {
+			log.Fatalf("Error parsing --modified-after: %v", err)
+		}
+	}
+
+	if *changedSource != "" {
+		if *current || *staged {
+			log.Fatal("--changed-files-source replaces --current and --staged (and the changed, staged and --hook shorthands); use one or the other")
+		}
+		if changedSources, err = parseChangedSources(*changedSource); err != nil {
+			log.Fatalf("Invalid --changed-files-source: %v", err)
+		}
+		if *onlyStagedHunks && changedSources != (changeSources{staged: true}) {
+			log.Fatal("--only-staged-hunks formats staged content; --changed-files-source can only be staged with it")
+		}
+		if *changedLinesOnly && changedSources.untracked {
+			log.Fatal("--check-only-changed-lines cannot be used with untracked files, which git diff does not show")
+		}
+	}
+	if *changedDirs && (len(fileArgs) > 0 || *fromFile != "" || *manifest != "" || *allFiles || *onlyStagedHunks || *headRev != "" || *changedLinesOnly) {
+		log.Fatal("--changed-dirs widens a git selection; it cannot be used with named files, --all, --only-staged-hunks, --head or --check-only-changed-lines")
+	}
+	selectStart := time.Now()
+	var files, filtered []string
+	var root string
+	walked := false
+	named := false
+	if *fromFile != "" {
+		listed, err := readFileList(*fromFile)
+		if err != nil {
+			log.Fatalf("Error reading --from-file: %v", err)
+		}
+		fileArgs = append(fileArgs, listed...)
+	}
+	if *manifest != "" {
+		listed, err := readManifest(*manifest)
+		if err != nil {
+			log.Fatalf("Error reading --manifest: %v", err)
+		}
+		fileArgs = append(fileArgs, listed...)
+	}
+	if len(fileArgs) > 0 || *fromFile != "" || *manifest != "" {
+		// Named files win over every other selection mode. An empty list
+		// still selects them, e.g. a --check report with nothing to fix.
+		root = "."
+		named = true
+		for _, arg := range fileArgs {
+			if isGlob(arg) {
+				// Shells like cmd.exe pass patterns through unexpanded.
+				matches, err := expandGlob(arg)
+				if err != nil {
+					log.Fatalf("Error expanding %s: %v", arg, err)
+				}
+				if len(matches) == 0 {
+					log.Fatalf("Error: no files match %s", arg)
+				}
+				files = append(files, matches...)
+				continue
+			}
+			info, err := os.Stat(arg)
+			if err != nil {
+				log.Fatalf("Error reading %s: %v", arg, err)
+			}
+			if info.IsDir() {
+				log.Fatalf("Error: %s is a directory; use --all from inside it instead", arg)
+			}
+			files = append(files, arg)
+		}
+	} else if *allFiles {
+		// The walk filters as it goes, so there is no separate filter phase.
+		root = "."
+		walked = true
+		filtered, err = walkFiles(root, extensions)
+		if err != nil {
+			log.Fatalf("Error walking directory: %v", err)
+		}
+	} else {
+		gitRoot, err := getGitRoot()
+		if err != nil {
+			log.Fatalf("Error finding Git repository: %v", err)
+		}
+		root = gitRoot
+
+		if *ciBase && *baseRef == "" {
+			if *baseRef = ciBaseRef(); *baseRef == "" {
+				log.Fatal("--ci-base: no base ref found in the CI environment")
+			}
+		}
+
+		if *fromHook == "pre-commit" {
+			files, err = getStagedFiles(gitRoot)
+			if err != nil {
+				log.Fatalf("Error getting staged files: %v", err)
+			}
+		} else if *fromHook == "pre-push" {
+			files, err = prePushFiles(gitRoot, os.Stdin)
+			if err != nil {
+				log.Fatalf("Error reading pre-push refs: %v", err)
+			}
+		} else if *fromHook != "" {
+			log.Fatalf("Unknown --from-hook %q: use pre-commit or pre-push", *fromHook)
+		} else if *patchFile != "" {
+			files, err = patchFiles(gitRoot, *patchFile)
+			if err != nil {
+				log.Fatalf("Error reading patch: %v", err)
+			}
+		} else if *baseRef != "" {
+			head := "HEAD"
+			if *headRev != "" {
+				head = *headRev
+			}
+			mergeBase, err := gitOutput(gitRoot, "merge-base", *baseRef, head)
+			if err != nil {
+				log.Fatalf("Error finding merge base with %s: %v", *baseRef, err)
+			}
+			diffRevs = []string{strings.TrimSpace(string(mergeBase))}
+			if *headRev != "" {
+				diffRevs = append(diffRevs, *headRev)
+			}
+			if *firstParent {
+				files, err = firstParentFiles(gitRoot, diffRevs[0], head, *headRev == "")
+			} else {
+				files, err = getChangedFilesAgainst(gitRoot, diffRevs...)
+			}
+			if err != nil {
+				log.Fatalf("Error getting changed files: %v", err)
+			}
+		} else if *stashRef != "" {
+			files, err = stashFiles(gitRoot, *stashRef)
+			if err != nil {
+				log.Fatalf("Error getting files in %s: %v", *stashRef, err)
+			}
+		} else if *mergeRev != "" {
+			files, err = mergeFiles(gitRoot, *mergeRev)
+			if err != nil {
+				log.Fatalf("Error getting files changed in merge %s: %v", *mergeRev, err)
+			}
+		} else if *sinceTag != "" {
+			tag, err := resolveTag(gitRoot, *sinceTag)
+			if err != nil {
+				log.Fatalf("Error resolving --since-tag: %v", err)
+			}
+			diffRevs = []string{tag + "..HEAD"}
+			if *firstParent {
+				files, err = firstParentFiles(gitRoot, tag, "HEAD", false)
+			} else {
+				files, err = getChangedFilesAgainst(gitRoot, diffRevs...)
+			}
+			if err != nil {
+				log.Fatalf("Error getting files changed since %s: %v", tag, err)
+			}
+		} else if *sinceLastRun {
+			state, err := readRunState()
+			if err != nil {
+				log.Fatalf("Error reading %s: %v", runStateFile, err)
+			}
+			if state == nil {
+				fmt.Fprintln(os.Stderr, "No previous run recorded; formatting the files changed since HEAD")
+				diffRevs = []string{"HEAD"}
+			} else {
+				diffRevs = []string{state.Head}
+			}
+			if *firstParent {
+				files, err = firstParentFiles(gitRoot, diffRevs[0], "HEAD", true)
+			} else {
+				files, err = getChangedFilesAgainst(gitRoot, diffRevs...)
+			}
+			if err != nil {
+				log.Fatalf("Error getting files changed since the last run (%s): %v", diffRevs[0], err)
+			}
+		} else if sources := selectedSources(); sources != (changeSources{}) {
+			diffRevs = sources.diffRevs()
+			files, err = getSourceFiles(gitRoot, sources)
+			if err != nil {
+				log.Fatalf("Error getting changed files: %v", err)
+			}
+			// Formatting staged blobs is the fix for partially staged files,
+			// and with the worktree selected too, the unstaged edits are
+			// meant to be formatted.
+			if sources.staged && !sources.worktree && !*onlyStagedHunks {
+				if err := checkPartiallyStaged(gitRoot, files); err != nil {
+					log.Fatalf("Refusing to format: %v", err)
+				}
+			}
+		} else {
+			// Nothing says what to format, so explain how to say it.
+			printHelp()
+			showedHelp = true
+			return 0
+		}
+		if *changedDirs {
+			if files, err = changedDirFiles(files); err != nil {
+				log.Fatalf("Error listing changed directories: %v", err)
+			}
+		}
+	}
+	stats.Selection = time.Since(selectStart)
+	if *headRev != "" && *baseRef == "" {
+		log.Fatal("--head needs --base (or --ci-base)")
+	}
+	if *firstParent && (*baseRef == "" && *sinceTag == "" && !*sinceLastRun || len(fileArgs) > 0 || *allFiles || *fromHook != "" || *patchFile != "" || *mergeRev != "" || *stashRef != "") {
+		log.Fatal("--first-parent needs a commit range from --base, --since-tag or --since-last-run")
+	}
+	if *firstParent && *changedLinesOnly {
+		// The changed lines come from git diff, which knows nothing of
+		// first parents.
+		log.Fatal("--first-parent cannot be used with --check-only-changed-lines")
+	}
+	if *changedLinesOnly && diffRevs == nil {
+		log.Fatal("--check-only-changed-lines needs a git selection: --current, --staged, --base, --since-tag or --since-last-run")
+	}
+
+	if named && *extList == "" && *langList == "" && !*autoExt && !*autoSelectExt && !*strictExt {
+		// The files were named on purpose, so the default extensions do
+		// not drop them; prettier reports any it has no parser for.
+		extensions = []string{""}
+	}
+	if !walked {
+		filterStart := time.Now()
+		if *autoSelectExt {
+			extensions = presentExtensions(extensions, files)
+			if *verbose {
+				fmt.Fprintf(os.Stderr, "Extensions of the selected files: %s\n", strings.Join(extensions, ", "))
+			}
+		}
+		filters, err := selectionFilters(extensions, root, nil)
+		if err != nil {
+			log.Fatalf("Error setting up the selection filters: %v", err)
+		}
+		if *respectGitignore {
+			ignored, err := gitIgnored(files)
+			if err != nil {
+				log.Fatalf("Error checking .gitignore: %v", err)
+			}
+			filters = append(filters, selectionFilter{skipIgnored, "--respect-gitignore", func(path string, _ os.FileInfo) bool {
+				return !ignored[path]
+			}})
+		}
+		if filtered, err = filterFiles(files, filters); err != nil {
+			log.Fatalf("Error filtering files: %v", err)
+		}
+		stats.Filtering = time.Since(filterStart)
+	}
+	if *resolveSymlinks {
+		if filtered, err = resolveSymlinkPaths(filtered); err != nil {
+			log.Fatalf("Error resolving symlinks: %v", err)
+		}
+	}
+	if conflicted := skipped.byReason()[skipConflicted]; len(conflicted) > 0 {
+		if *abortOnConflict || *strict {
+			log.Fatalf("Refusing to format: %d files have merge conflict markers:\n  %s", len(conflicted), strings.Join(conflicted, "\n  "))
+		}
+		fmt.Fprintf(os.Stderr, "Warning: skipping %d files with merge conflict markers; resolve them first:\n", len(conflicted))
+		for _, file := range conflicted {
+			fmt.Fprintln(os.Stderr, " ", file)
+		}
+	}
+	if *dumpSelection {
+		decisions.dump(os.Stderr)
+	}
+	if listOnly {
+		for _, file := range filtered {
+			if *print0 {
+				fmt.Printf("%s\x00", displayPath(file))
+			} else {
+				fmt.Println(displayPath(file))
+			}
+		}
+		return 0
+	}
+	if *countOnly {
+		fmt.Println(len(filtered))
+		return 0
+	}
+	if events != nil {
+		mode := "write"
+		if checkMode() {
+			mode = "check"
+		}
+		events.emit(runStartEvent{eventHeader: newEvent("run-start"), Mode: mode, Files: len(filtered), Jobs: *jobs})
+		defer func() {
+			events.emit(newRunEndEvent(summary, code))
+			if err := events.close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not write --emit-events: %v\n", err)
+			}
+		}()
+	}
+
+	if len(filtered) == 0 {
+		// This is the same whether git reported no changes or the filters
+		// dropped every candidate. Check mode has nothing to fail on, so it
+		// exits like a write run, and a machine-readable report is still
+		// written so its consumers always get a document.
+		if *dryRun && *reportFormat == "json" {
+			writeDryRun(filtered)
+			return *emptyExitCode
+		}
+		if machineReport() || *collapseOutput || *print0 {
+			res := Result{Mode: "write", Files: []string{}, Formatted: []string{}, Unchanged: []string{}, NeedsFormatting: []string{}}
+			if checkMode() {
+				res.Mode = "check"
+			}
+			if *reportSkipped || *collapseOutput {
+				res.Skipped = skipped.byReason()
+			}
+			summary = &res
+			writeReport(res)
+			return *emptyExitCode
+		}
+		if checkMode() {
+			fmt.Println("No files to check")
+		} else if !*summaryOnChange {
+			fmt.Println("No files to format")
+		}
+		if *reportSkipped {
+			printSkipped(os.Stdout, skipped.byReason())
+		}
+		if *printIgnored {
+			printIgnoredFiles(os.Stdout)
+		}
+		return *emptyExitCode
+	}
+	if *warnLargeSelection > 0 && len(filtered) > *warnLargeSelection {
+		warnLargeSelectionCount(os.Stderr, len(filtered))
+	}
+
+	if diffToolArgs != nil {
+		// Like --preview-dir, nothing in the tree is written.
+		reviewed, err := reviewInDiffTool(root, filtered)
+		if err != nil {
+			return prettierFailed("Error preparing the diff", err)
+		}
+		if reviewed == 0 {
+			fmt.Println("No files need formatting")
+		} else {
+			fmt.Printf("Reviewed %d files; nothing was written\n", reviewed)
+		}
+		return 0
+	}
+	if *dryRun {
+		if *diffStatOnly {
+			return printDiffStat(filtered)
+		}
+		if *reportFormat == "json" {
+			return writeDryRun(filtered)
+		}
+		for _, b := range parserGroups(filtered) {
+			printCommand(writeCommandLine(modeFlag(), b))
+		}
+		if *dryRunCheck {
+			// Nothing is written either way; this only decides the exit code.
+			unformatted, err := checkPrettier(filtered)
+			if err != nil {
+				return prettierFailed("Error checking files", err)
+			}
+			if len(unformatted) > 0 {
+				fmt.Printf("%d files would change:\n", len(unformatted))
+				printFileList(os.Stdout, unformatted)
+				return 1
+			}
+		}
+		return 0
+	}
+
+	if *previewDir != "" {
+		// Nothing in the tree is written, so there is nothing to confirm.
+		if err := previewFiles(root, filtered); err != nil {
+			return prettierFailed("Error previewing files", err)
+		}
+		fmt.Printf("Wrote %d formatted files to %s\n", len(filtered), *previewDir)
+		return 0
+	}
+
+	if *resume && (checkMode() || *onlyStagedHunks) {
+		log.Fatal("--resume cannot be used with --check, --no-write or --only-staged-hunks")
+	}
+	if !checkMode() && !*onlyStagedHunks && (*resumeJournalOn || *resume) {
+		done, err := openJournal()
+		if err != nil && *resume {
+			log.Fatalf("Error reading the resume journal: %v", err)
+		}
+		if n := len(filtered) - len(without(filtered, done)); n > 0 {
+			filtered = without(filtered, done)
+			fmt.Fprintf(os.Stderr, "Resuming: skipping %d files the interrupted run already formatted\n", n)
+		}
+		if len(filtered) == 0 {
+			journal.finish()
+			fmt.Println("Nothing left to format; the interrupted run had formatted every file.")
+			return 0
+		}
+		defer journal.close()
+	}
+	if *requireClean && !checkMode() {
+		if err := checkCleanWorktree(filtered); err != nil {
+			log.Fatalf("Refusing to format: %v", err)
+		}
+	}
+	if walked && !checkMode() && !*yes && len(filtered) > *confirmThreshold && !(*noConfirmIfClean && allCommitted(filtered)) {
+		prompt := fmt.Sprintf("This will format %d files recursively in the current directory. Do you want to continue? (yes/no): ", len(filtered))
+		if !confirmAction(prompt) {
+			fmt.Println("Operation canceled.")
+			return 0
+		}
+	}
+
+	res := Result{Mode: "write", Files: filtered, Formatted: []string{}, Unchanged: []string{}, NeedsFormatting: []string{}}
+	summary = &res
+	if *reportSkipped || *collapseOutput {
+		res.Skipped = skipped.byReason()
+	}
+	// A JUnit report counts files prettier failed on as errored test cases,
+	// and the collapsed summary counts them too, so both are still written
+	// when prettier fails.
+	failed := func(context string, err error) int {
+		code := prettierFailed(context, err)
+		if *reportFormat == "junit" || *collapseOutput {
+			res.Errored = fileErrors.byFile()
+			writeReport(res)
+		}
+		return code
+	}
+	if *onlyStagedHunks {
+		if checkMode() {
+			res.Mode = "check"
+		}
+		changed, err := formatStagedBlobs(root, filtered)
+		if err != nil {
+			return failed("Error formatting staged content", err)
+		}
+		if checkMode() {
+			res.NeedsFormatting = append(res.NeedsFormatting, changed...)
+		} else {
+			res.Formatted = append(res.Formatted, changed...)
+		}
+	} else if checkMode() {
+		res.Mode = "check"
+		checkFiles := checkPrettier
+		if *changedLinesOnly {
+			checkFiles = func(files []string) ([]string, error) {
+				return checkChangedLines(root, files)
+			}
+		}
+		if *headRev != "" {
+			checkFiles = func(files []string) ([]string, error) {
+				return checkBlobs(root, *headRev, files)
+			}
+		}
+		unformatted, err := checkFiles(filtered)
+		if err != nil && *autoPlugins && !*changedLinesOnly {
+			var retried []string
+			retried, err = retryWithPlugins("--list-different", err)
+			unformatted = append(unformatted, retried...)
+		}
+		res.NeedsFormatting = append(res.NeedsFormatting, unformatted...)
+		if err != nil {
+			return failed("Error checking files", err)
+		}
+		if *reportDiffStats {
+			res.DiffStats = fileDiffStats(res.NeedsFormatting)
+		}
+	} else if *formatModifiedOnly {
+		normalized, err := normalizeLineEndings(root, filtered)
+		if err != nil {
+			log.Fatalf("Error normalizing line endings: %v", err)
+		}
+		res.LineEndings = normalized
+		// Only files prettier would change are written, so the pre-pass
+		// already tells us exactly what gets formatted.
+		toWrite, err := checkPrettier(filtered)
+		if err != nil {
+			return failed("Error checking files", err)
+		}
+		if len(toWrite) > 0 {
+			// Normalized files were backed up before they were converted.
+			if err := backupFiles(root, without(toWrite, normalized)); err != nil {
+				log.Fatalf("Error backing up files: %v", err)
+			}
+			if err := runPrettier(toWrite); err != nil {
+				return failed("Error formatting files", err)
+			}
+		}
+		res.Formatted = append(res.Formatted, toWrite...)
+		res.Unchanged = append(res.Unchanged, without(filtered, toWrite)...)
+	} else {
+		normalized, err := normalizeLineEndings(root, filtered)
+		if err != nil {
+			log.Fatalf("Error normalizing line endings: %v", err)
+		}
+		res.LineEndings = normalized
+		var before map[string][sha256.Size]byte
+		if trackChanges() {
+			before = hashFiles(filtered)
+		}
+		if err := backupFiles(root, without(filtered, normalized)); err != nil {
+			log.Fatalf("Error backing up files: %v", err)
+		}
+		err = runPrettier(filtered)
+		if err != nil && *autoPlugins {
+			_, err = retryWithPlugins("--write", err)
+		}
+		if err != nil {
+			return failed("Error formatting files", err)
+		}
+		if trackChanges() {
+			res.Formatted = append(res.Formatted, changedSince(filtered, before)...)
+			res.Unchanged = append(res.Unchanged, without(filtered, res.Formatted)...)
+		}
+	}
+	if *formatThenCheck && res.Mode == "write" {
+		// Anything still reported here did not converge in one --write pass,
+		// which points at a prettier bug or conflicting configuration.
+		unformatted, err := checkPrettier(filtered)
+		res.NeedsFormatting = append(res.NeedsFormatting, unformatted...)
+		if err != nil {
+			return failed("Error verifying files", err)
+		}
+	}
+	if res.OutOfTime = outOfTime.sorted(); len(res.OutOfTime) > 0 {
+		res.Files = without(res.Files, res.OutOfTime)
+		res.Unchanged = without(res.Unchanged, res.OutOfTime)
+	}
+	code = writeReport(res)
+	if code == 0 && *writeVersionsLock {
+		if err := writeLock(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", lockFile, err)
+			return 1
+		}
+	}
+	if code == 0 && res.Mode == "write" && len(res.OutOfTime) == 0 {
+		journal.finish()
+		if err := writeRunState(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not record this run for --since-last-run: %v\n", err)
+		}
+	}
+	if code == 0 && res.Mode == "write" && postFormatArgs != nil {
+		if err := runPostFormat(res.Formatted); err != nil {
+			fmt.Fprintf(os.Stderr, "Error running --post-format-command: %v\n", err)
+			return 1
+		}
+	}
+	return code
+}
+
+// postFormatArgs holds the parsed --post-format-command, or nil.
+var postFormatArgs []string
+
+// runPostFormat runs the --post-format-command in the working directory,
+// with its output going to pretti's, and the formatted files one per line in
+// PRETTI_FORMATTED.
+func runPostFormat(formatted []string) error {
+	cmd := exec.Command(postFormatArgs[0], postFormatArgs[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "PRETTI_FORMATTED="+strings.Join(formatted, "\n"))
+	return cmd.Run()
+}
+
+// runStateFile is where a successful run is recorded for --since-last-run,
+// in the repository's git directory.
+const runStateFile = "pretti-state"
+
+// runState is the record of the last successful run that wrote files.
+type runState struct {
+	Time time.Time `json:"time"`
+	Head string    `json:"head"`
+}
+
+// readRunState returns the recorded state, or nil if no run was recorded.
+func readRunState() (*runState, error) {
+	gitDir, err := getGitDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(gitDir, runStateFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state runState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.Head == "" {
+		return nil, errors.New("no head commit recorded")
+	}
+	return &state, nil
+}
+
+// writeRunState records the current time and HEAD. Outside a repository, or
+// in one without commits, there is nothing to record.
+func writeRunState() error {
+	gitDir, err := getGitDir()
+	if err != nil {
+		return nil
+	}
+	head, err := exec.Command("git", "rev-parse", "--verify", "--quiet", "HEAD").Output()
+	if err != nil {
+		return nil
+	}
+	data, err := json.Marshal(runState{Time: time.Now().UTC(), Head: strings.TrimSpace(string(head))})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(gitDir, runStateFile), append(data, '\n'), 0o644)
+}
+
+// resumeFile is the journal of the files the current write run has
+// formatted, in the repository's git directory, kept with --resume-journal or
+// --resume. A run that finishes cleanly removes it; one that is interrupted,
+// fails or runs out of --time-budget leaves it for --resume.
+const resumeFile = "pretti-resume"
+
+// resumeJournal appends formatted files to the resume journal as their
+// batches finish. A nil journal records nothing.
+type resumeJournal struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+}
+
+// journal is the current run's resume journal, or nil when there is none.
+var journal *resumeJournal
+
+// resumeKey identifies a run by its working directory and arguments, leaving
+// out --resume and --resume-journal themselves, so only a rerun of the same
+// command resumes.
+func resumeKey() string {
+	wd, _ := os.Getwd()
+	h := sha256.New()
+	fmt.Fprintln(h, wd)
+	for _, arg := range os.Args[1:] {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && (name == "resume" || name == "resume-journal") {
+			continue
+		}
+		fmt.Fprintln(h, arg)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// openJournal starts the resume journal for this run. With --resume and a
+// journal left by a run with the same key, it returns the files that run
+// formatted and keeps appending to it; otherwise the journal starts over.
+func openJournal() ([]string, error) {
+	gitDir, err := getGitDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(gitDir, resumeFile)
+	key := resumeKey()
+	var done []string
+	if *resume {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		switch {
+		case len(data) == 0:
+			fmt.Fprintln(os.Stderr, "Warning: no interrupted run to resume; formatting every selected file")
+		case lines[0] != "key "+key:
+			fmt.Fprintln(os.Stderr, "Warning: the interrupted run had different arguments; formatting every selected file")
+		default:
+			done = lines[1:]
+		}
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if done == nil {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if done == nil {
+		if _, err := fmt.Fprintf(f, "key %s\n", key); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	journal = &resumeJournal{f: f, path: path}
+	return done, nil
+}
+
+// add records files whose prettier batch succeeded.
+func (j *resumeJournal) add(files []string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return
+	}
+	var sb strings.Builder
+	for _, file := range files {
+		sb.WriteString(file)
+		sb.WriteByte('\n')
+	}
+	if _, err := j.f.WriteString(sb.String()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not update the resume journal: %v\n", err)
+	}
+}
+
+// close leaves the journal in place for a later --resume.
+func (j *resumeJournal) close() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f != nil {
+		j.f.Close()
+		j.f = nil
+	}
+}
+
+// finish removes the journal once the run no longer needs resuming.
+func (j *resumeJournal) finish() {
+	if j == nil {
+		return
+	}
+	j.close()
+	if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Warning: could not remove the resume journal: %v\n", err)
+	}
+}
+
+// checkMode reports whether files should be checked instead of written.
+func checkMode() bool {
+	return *check || *noWrite
+}
+
+// prettierBin returns the prettier executable to run.
+func prettierBin() string {
+	if *prettierPath != "" {
+		return *prettierPath
+	}
+	return "prettier"
+}
+
+// runners maps each --runner name to the command that launches prettier
+// through it. direct is left empty and runs prettierBin.
+var runners = map[string][]string{
+	"direct": nil,
+	// --no-install stops npx from downloading prettier when it's missing,
+	// so a project without it falls through to the next runner.
+	"npx":  {"npx", "--no-install", "prettier"},
+	"pnpm": {"pnpm", "exec", "prettier"},
+	"yarn": {"yarn", "prettier"},
+	"bunx": {"bunx", "prettier"},
+}
+
+// runnerCmdline is the command resolveRunner picked, or nil to run
+// prettierBin directly.
+var runnerCmdline []string
+
+// resolveRunner picks the first runner in the comma-separated list that can
+// launch prettier, judged by running prettier --version through it, and
+// returns its name.
+func resolveRunner(list string) (string, error) {
+	names := strings.Split(list, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		if _, ok := runners[names[i]]; !ok {
+			return "", fmt.Errorf("unknown runner %q (want direct, npx, pnpm, yarn or bunx)", names[i])
+		}
+	}
+	if *prettierPath != "" && !slices.Contains(names, "direct") {
+		return "", fmt.Errorf("--prettier-path only applies to the direct runner")
+	}
+	var failures []string
+	for _, name := range names {
+		line := runners[name]
+		if line == nil {
+			line = []string{prettierBin()}
+		}
+		cmd := exec.Command(line[0], append(slices.Clip(line[1:]), "--version")...)
+		if err := cmd.Run(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		runnerCmdline = line
+		return name, nil
+	}
+	return "", fmt.Errorf("no runner could launch prettier (%s)", strings.Join(failures, "; "))
+}
+
+// prettierCmdline returns the command that launches prettier, before any
+// of its arguments.
+func prettierCmdline() []string {
+	if runnerCmdline != nil {
+		return runnerCmdline
+	}
+	return []string{prettierBin()}
+}
+
+// prettierCommand returns the command that runs prettier with args through
+// the chosen runner.
+func prettierCommand(ctx context.Context, args ...string) *exec.Cmd {
+	line := prettierCmdline()
+	return exec.CommandContext(ctx, line[0], append(slices.Clip(line[1:]), args...)...)
+}
+
+// checkPrettierVersion fails unless the resolved prettier's --version
+// satisfies constraint: an exact version such as 3.3.3, ^3.3.0 (same major
+// version, at least 3.3.0) or ~3.3.0 (same minor version, at least 3.3.0),
+// with npm's semver rules for the edge cases.
+func checkPrettierVersion(constraint string) error {
+	version, err := prettierVersion()
+	if err != nil {
+		return err
+	}
+	ok, err := versionSatisfies(version, constraint)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("prettier %s does not satisfy the required version %s", version, constraint)
+	}
+	return nil
+}
+
+// prettierVersion returns what the resolved prettier's --version prints.
+func prettierVersion() (string, error) {
+	out, err := prettierCommand(context.Background(), "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run prettier --version: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// lockFile is where --write-versions-lock records the formatter versions,
+// next to .prettirc.
+const lockFile = ".pretti.lock"
+
+// versionsLock is the content of the lock file.
+type versionsLock struct {
+	Prettier string `json:"prettier"`
+	// Plugins maps each --plugin to its installed version, or to the sha256
+	// of the file for a plugin given as a path.
+	Plugins map[string]string `json:"plugins,omitempty"`
+	// ConfigFiles are the prettier config files ConfigHash covers.
+	ConfigFiles []string `json:"configFiles"`
+	ConfigHash  string   `json:"configHash"`
+}
+
+// prettierConfigFiles are the config files prettier looks for, checked at the
+// root for the config hash. Nested configs are not covered.
+var prettierConfigFiles = []string{
+	".prettierrc", ".prettierrc.json", ".prettierrc.json5", ".prettierrc.yaml", ".prettierrc.yml", ".prettierrc.toml",
+	".prettierrc.js", ".prettierrc.cjs", ".prettierrc.mjs", ".prettierrc.ts", "prettier.config.js", "prettier.config.cjs",
+	"prettier.config.mjs", "prettier.config.ts", ".editorconfig",
+}
+
+// currentLock describes the prettier this run would use, rooted at dir.
+func currentLock(dir string) (versionsLock, error) {
+	var lock versionsLock
+	var err error
+	if lock.Prettier, err = prettierVersion(); err != nil {
+		return lock, err
+	}
+	for _, plugin := range *plugins {
+		version := "not installed"
+		if isPluginPath(plugin) {
+			if data, err := os.ReadFile(plugin); err == nil {
+				version = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+			}
+		} else if v, ok := pluginVersion(plugin); ok {
+			version = v
+		}
+		if lock.Plugins == nil {
+			lock.Plugins = make(map[string]string)
+		}
+		lock.Plugins[plugin] = version
+	}
+
+	configs := prettierConfigFiles
+	if path := prettierConfigPath(); path != "" {
+		configs = []string{path}
+	}
+	h := sha256.New()
+	lock.ConfigFiles = []string{}
+	for _, name := range configs {
+		path := name
+		if !filepath.IsAbs(path) && prettierConfigPath() == "" {
+			path = filepath.Join(dir, name)
+		}
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return lock, err
+		}
+		lock.ConfigFiles = append(lock.ConfigFiles, filepath.ToSlash(name))
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(name), len(data))
+		h.Write(data)
+	}
+	lock.ConfigHash = fmt.Sprintf("sha256:%x", h.Sum(nil))
+	return lock, nil
+}
+
+// lockPath returns where the lock file lives.
+func lockPath() string {
+	return filepath.Join(filepath.Dir(projectConfigPath()), lockFile)
+}
+
+// writeLock records the current versions for --write-versions-lock.
+func writeLock() error {
+	path := lockPath()
+	lock, err := currentLock(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// verifyLock fails for --verify-versions-lock unless the current versions
+// match the lock file, naming everything that differs.
+func verifyLock() error {
+	path := lockPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w (create it with --write-versions-lock)", lockFile, err)
+	}
+	var locked versionsLock
+	if err := json.Unmarshal(data, &locked); err != nil {
+		return fmt.Errorf("%s: %w", lockFile, err)
+	}
+	lock, err := currentLock(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	var diffs []string
+	if lock.Prettier != locked.Prettier {
+		diffs = append(diffs, fmt.Sprintf("prettier is %s, locked at %s", lock.Prettier, locked.Prettier))
+	}
+	names := maps.Clone(lock.Plugins)
+	if names == nil {
+		names = map[string]string{}
+	}
+	maps.Copy(names, locked.Plugins)
+	for _, name := range sortedKeys(names) {
+		have, ok := lock.Plugins[name]
+		want, wasLocked := locked.Plugins[name]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("plugin %s is locked at %s but not passed with --plugin", name, want))
+		case !wasLocked:
+			diffs = append(diffs, fmt.Sprintf("plugin %s (%s) is not in the lock", name, have))
+		case have != want:
+			diffs = append(diffs, fmt.Sprintf("plugin %s is %s, locked at %s", name, have, want))
+		}
+	}
+	if lock.ConfigHash != locked.ConfigHash {
+		diffs = append(diffs, fmt.Sprintf("the prettier config (%s) differs from the locked one (%s)", strings.Join(lock.ConfigFiles, ", "), strings.Join(locked.ConfigFiles, ", ")))
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("the environment does not match %s: %s", lockFile, strings.Join(diffs, "; "))
+	}
+	return nil
+}
+
+// versionSatisfies reports whether version matches constraint, as described
+// for checkPrettierVersion, following npm's semver rules. A full exact
+// constraint matches only that version, pre-release tag included, and one
+// with fewer parts, such as 3.3, any release starting with them. ^ allows
+// changes right of the first nonzero part given (^0.0.3 is only 0.0.3), and ~
+// allows patch changes, or minor ones when only a major is given (~3 is any
+// 3.x). A pre-release only satisfies a range whose own bound is a pre-release
+// of the same version.
+func versionSatisfies(version, constraint string) (bool, error) {
+	have, _, err := parseVersion(version)
+	if err != nil {
+		return false, fmt.Errorf("prettier version %q: %w", version, err)
+	}
+	havePre := prerelease(version)
+
+	var op string
+	if strings.HasPrefix(constraint, "^") || strings.HasPrefix(constraint, "~") || strings.HasPrefix(constraint, "=") {
+		op, constraint = constraint[:1], constraint[1:]
+	}
+	want, parts, err := parseVersion(constraint)
+	if err != nil {
+		return false, fmt.Errorf("required version %q: %w", constraint, err)
+	}
+	wantPre := prerelease(constraint)
+
+	if op == "" || op == "=" {
+		if parts < 3 {
+			return havePre == "" && slices.Equal(have[:parts], want[:parts]), nil
+		}
+		return have == want && havePre == wantPre, nil
+	}
+	if havePre != "" && (wantPre == "" || have != want) {
+		return false, nil
+	}
+	if compareVersions(have, havePre, want, wantPre) < 0 {
+		return false, nil
+	}
+	// The constraint fixes the parts up to fixed; the version must be below
+	// the one with that part incremented.
+	fixed := min(parts, 2) - 1
+	if op == "^" {
+		fixed = parts - 1
+		for i := range parts {
+			if want[i] != 0 {
+				fixed = i
+				break
+			}
+		}
+	}
+	var upper [3]int
+	copy(upper[:], want[:fixed])
+	upper[fixed] = want[fixed] + 1
+	return slices.Compare(have[:], upper[:]) < 0, nil
+}
+
+// prerelease returns the pre-release tag of a version, such as beta.1 in
+// 3.4.0-beta.1+build, or "" for a release.
+func prerelease(version string) string {
+	version, _, _ = strings.Cut(strings.TrimSpace(version), "+")
+	_, pre, _ := strings.Cut(version, "-")
+	return pre
+}
+
+// compareVersions orders two versions with their pre-release tags the way
+// semver does: a pre-release comes before its release, and tags compare
+// field by field, numeric fields numerically and below alphanumeric ones.
+func compareVersions(a [3]int, aPre string, b [3]int, bPre string) int {
+	if c := slices.Compare(a[:], b[:]); c != 0 || aPre == bPre {
+		return c
+	}
+	if aPre == "" {
+		return 1
+	}
+	if bPre == "" {
+		return -1
+	}
+	af, bf := strings.Split(aPre, "."), strings.Split(bPre, ".")
+	for i := 0; i < len(af) && i < len(bf); i++ {
+		an, aErr := strconv.Atoi(af[i])
+		bn, bErr := strconv.Atoi(bf[i])
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = cmp.Compare(an, bn)
+		case aErr == nil:
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(af[i], bf[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(af), len(bf))
+}
+
+// parseVersion parses a major[.minor[.patch]] version, with an optional
+// leading v and pre-release or build suffix, and returns how many parts were
+// given. Missing parts are 0.
+func parseVersion(s string) (v [3]int, parts int, err error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	fields := strings.Split(s, ".")
+	if len(fields) > 3 {
+		return v, 0, errors.New("too many version parts")
+	}
+	for i, field := range fields {
+		if v[i], err = strconv.Atoi(field); err != nil || v[i] < 0 {
+			return v, 0, fmt.Errorf("invalid version part %q", field)
+		}
+	}
+	return v, len(fields), nil
+}
+
+// checkExecutable verifies that path is a regular file that can be executed.
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if runtime.GOOS != "windows" && info.Mode()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", path)
+	}
+	return nil
+}
+
+// stringList is a flag.Value that collects every occurrence of a repeatable
+// flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// reset empties the list, so a source of higher precedence in loadConfig
+// replaces the values of a lower one rather than adding to them.
+func (l *stringList) reset() { *l = nil }
+
+// resetList empties the named flag if it is a stringList.
+func resetList(name string) {
+	if f := flag.Lookup(name); f != nil {
+		if l, ok := f.Value.(*stringList); ok {
+			l.reset()
+		}
+	}
+}
+
+// listFlag defines a repeatable string flag.
+func listFlag(name, usage string) *stringList {
+	l := new(stringList)
+	flag.Var(l, name, usage)
+	return l
+}
+
+// prettierOption describes how a prettier option is spelled on its CLI.
+type prettierOption struct {
+	flag   string
+	isBool bool
+}
+
+// prettierOptions maps the config-file names accepted by --opt to prettier's
+// CLI flags.
+var prettierOptions = map[string]prettierOption{
+	"arrowParens":                {"--arrow-parens", false},
+	"bracketSameLine":            {"--bracket-same-line", true},
+	"bracketSpacing":             {"--bracket-spacing", true},
+	"embeddedLanguageFormatting": {"--embedded-language-formatting", false},
+	"endOfLine":                  {"--end-of-line", false},
+	"htmlWhitespaceSensitivity":  {"--html-whitespace-sensitivity", false},
+	"jsxSingleQuote":             {"--jsx-single-quote", true},
+	"printWidth":                 {"--print-width", false},
+	"proseWrap":                  {"--prose-wrap", false},
+	"quoteProps":                 {"--quote-props", false},
+	"semi":                       {"--semi", true},
+	"singleAttributePerLine":     {"--single-attribute-per-line", true},
+	"singleQuote":                {"--single-quote", true},
+	"tabWidth":                   {"--tab-width", false},
+	"trailingComma":              {"--trailing-comma", false},
+	"useTabs":                    {"--use-tabs", true},
+}
+
+// optArgs holds the prettier arguments translated from --opt.
+var optArgs []string
+
+// translateOpts turns key=value pairs into prettier CLI arguments. Boolean
+// options become --flag or --no-flag.
+func translateOpts(opts []string) ([]string, error) {
+	var args []string
+	for _, opt := range opts {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not in key=value form", opt)
+		}
+		option, known := prettierOptions[key]
+		if !known {
+			return nil, fmt.Errorf("unknown option %q (supported: %s)", key, strings.Join(sortedKeys(prettierOptions), ", "))
+		}
+		if !option.isBool {
+			args = append(args, option.flag, value)
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s expects true or false, got %q", key, value)
+		}
+		if enabled {
+			args = append(args, option.flag)
+		} else {
+			args = append(args, "--no-"+strings.TrimPrefix(option.flag, "--"))
+		}
+	}
+	return args, nil
+}
+
+// prettierColor reports whether prettier may color its output: not with
+// --no-prettier-color or $NO_COLOR, and only when stdout is a terminal, so
+// escape codes never end up in CI logs or redirected files.
+func prettierColor() bool {
+	if *noPrettierColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTerminal(os.Stdout)
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// extraArgs holds the parsed --prettier-args, set once flags are parsed.
+var extraArgs []string
+
+// splitArgs splits s into words the way a POSIX shell would, minus
+// expansions: words are separated by unquoted whitespace, single quotes keep
+// everything literally, and inside double quotes or unquoted a backslash
+// escapes the next character.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// frameworkPlugins maps the extensions of framework files prettier can only
+// parse with a plugin to the plugin package, for --auto-plugins.
+var frameworkPlugins = map[string]string{
+	".svelte": "prettier-plugin-svelte",
+	".astro":  "prettier-plugin-astro",
+}
+
+// retryWithPlugins is the --auto-plugins fallback for a failed run: each file
+// prettier failed on is run once more in mode with its framework's plugin.
+// Unless every failed file has such a plugin installed, and not already
+// loaded, err is returned as it is. Otherwise it returns the files the
+// retries reported (for --list-different) and the first error they hit.
+func retryWithPlugins(mode string, err error) ([]string, error) {
+	failed := fileErrors.byFile()
+	if len(failed) == 0 || *failFast || *maxErrors > 0 {
+		// The run may have stopped before reaching every file.
+		return nil, err
+	}
+	retries := map[string]string{}
+	for file := range failed {
+		plugin := frameworkPlugins[filepath.Ext(file)]
+		if plugin == "" || slices.Contains(*plugins, plugin) || !pluginInstalled(plugin) {
+			return nil, err
+		}
+		retries[file] = plugin
+	}
+
+	var reported []string
+	for _, file := range slices.Sorted(maps.Keys(retries)) {
+		fmt.Fprintf(os.Stderr, "Retrying %s with --plugin %s\n", file, retries[file])
+		cmd := prettierCommand(context.Background(), prettierArgs(mode, []string{"--plugin", retries[file], file})...)
+		cmd.Stderr = prettierStderr()
+		out, err := cmd.Output()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			// --list-different exits 1 for a file that needs formatting.
+			if mode != "--list-different" || exitErr.ExitCode() != 1 {
+				return reported, &prettierExitError{code: exitErr.ExitCode()}
+			}
+		} else if err != nil {
+			return reported, fmt.Errorf("failed to run prettier: %w", err)
+		}
+		fileErrors.remove(file)
+		if mode == "--write" {
+			prettierStdout().Write(out)
+		} else if len(bytes.TrimSpace(out)) > 0 {
+			reported = append(reported, file)
+		}
+	}
+	return reported, nil
+}
+
+// pluginInstalled reports whether a node_modules directory in the working
+// directory or one of its parents has the package, which is where prettier
+// resolves plugins named on its command line.
+func pluginInstalled(name string) bool {
+	return pluginPackage(name) != ""
+}
+
+// pluginPackage returns the package.json of the installed package name, found
+// the way pluginInstalled describes, or "".
+func pluginPackage(name string) string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, "node_modules", name, "package.json")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// pluginVersion returns the version in the installed package's package.json.
+func pluginVersion(name string) (string, bool) {
+	path := pluginPackage(name)
+	if path == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	var pkg struct {
+		Version string `json:"version"`
+	}
+	if json.Unmarshal(data, &pkg) != nil || pkg.Version == "" {
+		return "", false
+	}
+	return pkg.Version, true
+}
+
+// isPluginPath reports whether a --plugin value names a file rather than a
+// package prettier resolves itself, such as @scope/prettier-plugin-x.
+func isPluginPath(plugin string) bool {
+	if filepath.IsAbs(plugin) || strings.HasPrefix(plugin, "./") || strings.HasPrefix(plugin, "../") {
+		return true
+	}
+	switch filepath.Ext(plugin) {
+	case ".js", ".mjs", ".cjs":
+		return true
+	}
+	return false
+}
+
+// prettierConfigPath returns the prettier config file to pass on: --config
+// if given, otherwise $PRETTIER_CONFIG. Prettier itself ignores that variable.
+func prettierConfigPath() string {
+	if *prettierConfig != "" {
+		return *prettierConfig
+	}
+	return os.Getenv("PRETTIER_CONFIG")
+}
+
+// modeFlag returns the prettier flag that selects between writing files and
+// listing the ones that differ.
+func modeFlag() string {
+	if checkMode() {
+		return "--list-different"
+	}
+	return "--write"
+}
+
+// prettierArgs builds the prettier argument list for the given mode flag,
+// adding any pass-through options before the file list. An empty mode makes
+// prettier print the formatted content to stdout.
+func prettierArgs(mode string, files []string) []string {
+	var args []string
+	if mode != "" {
+		args = append(args, mode)
+	}
+	if path := prettierConfigPath(); path != "" {
+		args = append(args, "--config", path)
+	}
+	if !prettierColor() {
+		args = append(args, "--no-color")
+	}
+	args = append(args, optArgs...)
+	for _, plugin := range *plugins {
+		args = append(args, "--plugin", plugin)
+	}
+	if *noIgnore {
+		// Prettier skips files named in .gitignore and .prettierignore
+		// even when they are passed explicitly.
+		args = append(args, "--ignore-path", os.DevNull)
+	}
+	if *prettierCache {
+		args = append(args, "--cache")
+		if *prettierCacheLocation != "" {
+			args = append(args, "--cache-location", *prettierCacheLocation)
+		}
+	}
+	if *requirePragma {
+		args = append(args, "--require-pragma")
+	}
+	if *insertPragma {
+		args = append(args, "--insert-pragma")
+	}
+	args = append(args, extraArgs...)
+	return append(args, files...)
+}
+
+// printCommand prints the command line for --dry-run, quoting any argument
+// that would not survive a copy-paste into a shell.
+func printCommand(line []string) {
+	quoted := make([]string, len(line))
+	for i, arg := range line {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$*?") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	fmt.Println(strings.Join(quoted, " "))
+}
+
+// templateArgs holds the parsed --command-template, or nil to run prettier
+// --write.
+var templateArgs []string
+
+// parseTemplate splits a --command-template into words with splitArgs. The
+// placeholders {bin}, {args} and {files} must be words of their own, and
+// {files} is required: without it the command would not know what to
+// format.
+func parseTemplate(template string) ([]string, error) {
+	if template == "" {
+		return nil, nil
+	}
+	words, err := splitArgs(template)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range words {
+		switch word {
+		case "{bin}", "{args}", "{files}":
+		default:
+			if strings.Contains(word, "{bin}") || strings.Contains(word, "{args}") || strings.Contains(word, "{files}") {
+				return nil, fmt.Errorf("placeholder in %q must be a word of its own", word)
+			}
+		}
+	}
+	if !slices.Contains(words, "{files}") {
+		return nil, fmt.Errorf("%q has no {files} placeholder", template)
+	}
+	return words, nil
+}
+
+// writeCommandLine returns the full command line that formats b in mode:
+// prettier with prettierArgs, or for --write with a --command-template, the
+// template with {bin} replaced by the command that launches prettier,
+// {args} by the options pretti would pass it and {files} by the batch.
+func writeCommandLine(mode string, b batch) []string {
+	if templateArgs == nil || mode != "--write" {
+		return append(slices.Clip(prettierCmdline()), prettierArgs(mode, b.paths())...)
+	}
+	var line []string
+	for _, word := range templateArgs {
+		switch word {
+		case "{bin}":
+			line = append(line, prettierCmdline()...)
+		case "{args}":
+			line = append(line, prettierArgs("", nil)...)
+		case "{files}":
+			line = append(line, b.paths()...)
+		default:
+			line = append(line, word)
+		}
+	}
+	return line
+}
+
+// enterRoot makes dir the working directory, so git runs there and relative
+// paths resolve against it. Unless --no-git is set, dir must be the top of a
+// repository: .git may be a directory or, in a linked worktree, a file.
+func enterRoot(dir string) error {
+	if !*noGit {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("%s has no .git; pass --no-git to use it anyway", dir)
+			}
+			return err
+		}
+	}
+	return os.Chdir(dir)
+}
+
+// getGitRoot returns the repository root: the --root directory when one was
+// given, otherwise whatever git finds from the working directory.
+func getGitRoot() (string, error) {
+	if *rootDir != "" {
+		if *noGit {
+			return "", errors.New("--no-git is set; use --all or name the files")
+		}
+		return os.Getwd()
+	}
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// getGitDir returns the absolute path of the repository's git directory.
+// Anything pretti stores under .git must go through this rather than joining
+// ".git" onto the root: in a linked worktree .git is a file pointing at
+// .git/worktrees/<name> in the main repository.
+func getGitDir() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--git-dir").Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse --git-dir failed: %w", err)
+	}
+	return filepath.Abs(strings.TrimSpace(string(out)))
+}
+
+// getChangedFiles returns the files with unstaged changes: the working tree
+// against the index.
+func getChangedFiles(gitRoot string) ([]string, error) {
+	return gitDiffFiles(gitRoot)
+}
+
+// getStagedFiles returns the files staged for commit: the index against
+// HEAD.
+func getStagedFiles(gitRoot string) ([]string, error) {
+	return gitDiffFiles(gitRoot, "--cached")
+}
+
+// getChangedFilesAgainst returns the files that differ between revs and the
+// working tree (or between two revisions when given a range).
+func getChangedFilesAgainst(gitRoot string, revs ...string) ([]string, error) {
+	return gitDiffFiles(gitRoot, revs...)
+}
+
+// prePushFiles returns the files changed by the commits a push sends, read
+// from the "<local ref> <local sha> <remote ref> <remote sha>" lines git
+// gives a pre-push hook on stdin, where an all-zero sha stands for a ref
+// that does not exist on that side. Deleted refs are ignored. For a ref the
+// remote does not have yet, the commits not on any remote-tracking branch are
+// used.
+func prePushFiles(gitRoot string, r io.Reader) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 {
+			continue
+		}
+		localSHA, remoteSHA := fields[1], fields[3]
+		if strings.Trim(localSHA, "0") == "" {
+			continue
+		}
+
+		var changed []string
+		var err error
+		if strings.Trim(remoteSHA, "0") == "" {
+			changed, err = unpushedFiles(gitRoot, localSHA)
+		} else {
+			changed, err = getChangedFilesAgainst(gitRoot, remoteSHA+".."+localSHA)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range changed {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files, scanner.Err()
+}
+
+// firstParentFiles returns the files touched by the commits in from..to on
+// to's first-parent line, for --first-parent: a merge on that line adds
+// nothing, so files only its other parents changed are left out. git diff
+// cannot do this, so it comes from git log. With worktree set, files with
+// uncommitted changes are added, as git diff against from would include
+// them.
+func firstParentFiles(gitRoot, from, to string, worktree bool) ([]string, error) {
+	out, err := gitOutput(gitRoot, "log", "--first-parent", "--no-merges", "--format=", "--name-only", "--diff-filter=d", from+".."+to)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var files []string
+	for _, file := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if file != "" && !seen[file] {
+			seen[file] = true
+			files = append(files, filepath.Join(gitRoot, file))
+		}
+	}
+	if worktree {
+		uncommitted, err := getChangedFilesAgainst(gitRoot, "HEAD")
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range uncommitted {
+			if rel := relPath(gitRoot, file); !seen[rel] {
+				seen[rel] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// unpushedFiles returns the files touched by the commits reachable from rev
+// that no remote-tracking branch contains.
+func unpushedFiles(gitRoot, rev string) ([]string, error) {
+	out, err := gitOutput(gitRoot, "log", "--format=", "--name-only", "--diff-filter=d", rev, "--not", "--remotes")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if file != "" {
+			files = append(files, filepath.Join(gitRoot, file))
+		}
+	}
+	return files, nil
+}
+
+// patchFiles returns the files a unified diff touches, read from its "+++"
+// headers and resolved against gitRoot. Files the patch deletes have a
+// "+++ /dev/null" header and are left out.
+func patchFiles(gitRoot, patch string) ([]string, error) {
+	data, err := os.ReadFile(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		name, ok := strings.CutPrefix(line, "+++ ")
+		if !ok {
+			continue
+		}
+		// Plain diff -u appends a timestamp after a tab.
+		name, _, _ = strings.Cut(strings.TrimRight(name, "\r"), "\t")
+		if unquoted, err := strconv.Unquote(name); err == nil {
+			name = unquoted
+		}
+		if name == "/dev/null" {
+			continue
+		}
+		name = strings.TrimPrefix(name, "b/")
+		files = append(files, filepath.Join(gitRoot, name))
+	}
+	return files, nil
+}
+
+// latestTag is the --since-tag value that stands for the most recent tag
+// reachable from HEAD.
+const latestTag = "@latest"
+
+// resolveTag returns tag, or the most recent tag reachable from HEAD when tag
+// is latestTag.
+func resolveTag(gitRoot, tag string) (string, error) {
+	if tag != latestTag {
+		return tag, nil
+	}
+	out, err := gitOutput(gitRoot, "describe", "--tags", "--abbrev=0")
+	if err != nil {
+		return "", fmt.Errorf("no tag reachable from HEAD: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// mergeFiles returns the files in merge commit rev's combined diff (git
+// diff-tree --cc): those whose merged content matches none of the parents,
+// such as files edited while resolving conflicts. Files taken unchanged from
+// either side are left out, as are deleted files.
+func mergeFiles(gitRoot, rev string) ([]string, error) {
+	if _, err := gitOutput(gitRoot, "rev-parse", "--verify", "--quiet", rev+"^2"); err != nil {
+		return nil, fmt.Errorf("%s is not a merge commit", rev)
+	}
+	out, err := gitOutput(gitRoot, "diff-tree", "-r", "--cc", "--name-only", "--no-commit-id", "--diff-filter=d", rev)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if file != "" {
+			files = append(files, filepath.Join(gitRoot, file))
+		}
+	}
+	return files, nil
+}
+
+// changedDirFiles returns, for --changed-dirs, every file directly in the
+// directories that hold one of files, each directory listed once and in the
+// order its first file came. Subdirectories are not entered; the selection
+// filters then apply to the files as usual.
+func changedDirFiles(files []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var all []string
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			// Every changed file in it was deleted.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				all = append(all, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return all, nil
+}
+
+// stashFiles returns the files the stash ref changed or added: what it
+// recorded of the working tree and the index against the commit it was
+// made on, and the untracked files of a stash made with --include-untracked.
+// Deleted files are left out. The files are formatted as they are now, so
+// this is meant for after the stash was popped or applied.
+func stashFiles(gitRoot, ref string) ([]string, error) {
+	// A stash is a merge of its base commit and the index commit.
+	if _, err := gitOutput(gitRoot, "rev-parse", "--verify", "--quiet", ref+"^2"); err != nil {
+		return nil, fmt.Errorf("%s is not a stash", ref)
+	}
+	seen := make(map[string]bool)
+	var files []string
+	add := func(out []byte) {
+		for _, file := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if file != "" && !seen[file] {
+				seen[file] = true
+				files = append(files, filepath.Join(gitRoot, file))
+			}
+		}
+	}
+	for _, commit := range []string{ref, ref + "^2"} {
+		out, err := gitOutput(gitRoot, "diff-tree", "-r", "--name-only", "--no-commit-id", "--diff-filter=d", ref+"^1", commit)
+		if err != nil {
+			return nil, err
+		}
+		add(out)
+	}
+	if _, err := gitOutput(gitRoot, "rev-parse", "--verify", "--quiet", ref+"^3"); err == nil {
+		out, err := gitOutput(gitRoot, "ls-tree", "-r", "--name-only", ref+"^3")
+		if err != nil {
+			return nil, err
+		}
+		add(out)
+	}
+	return files, nil
+}
+
+// ciBaseRef returns the pull/merge request base provided by a supported CI
+// system, or "" if none is found.
+func ciBaseRef() string {
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		if ref := os.Getenv("GITHUB_BASE_REF"); ref != "" {
+			return "origin/" + ref
+		}
+	}
+	if os.Getenv("GITLAB_CI") == "true" {
+		if sha := os.Getenv("CI_MERGE_REQUEST_DIFF_BASE_SHA"); sha != "" {
+			return sha
+		}
+		if ref := os.Getenv("CI_MERGE_REQUEST_TARGET_BRANCH_NAME"); ref != "" {
+			return "origin/" + ref
+		}
+	}
+	return ""
+}
+
+// gitDiffFiles runs git diff --name-only with the given extra arguments and
+// returns the reported paths joined onto the repository root. Deleted files
+// are left out in every mode: one removed with git rm --cached is still on
+// disk, and formatting and restaging it would add it back. A renamed file is
+// listed under its new path, whether git pairs it with the old one (R) or,
+// with diff.renames off, reports it as added.
+func gitDiffFiles(gitRoot string, args ...string) ([]string, error) {
+	cmd := exec.Command("git", append([]string{"diff", "--name-only", "--diff-filter=d"}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %w", err)
+	}
+
+	var files []string
+	for _, file := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if file == "" {
+			continue
+		}
+		files = append(files, filepath.Join(gitRoot, file))
+	}
+	return files, nil
+}
+
+// changeSources is a set of what --changed-files-source counts as changed.
+type changeSources struct {
+	// worktree is unstaged changes: the working tree against the index.
+	worktree bool
+	// staged is the index against HEAD.
+	staged bool
+	// untracked is new files git does not ignore.
+	untracked bool
+}
+
+// changedSources holds the parsed --changed-files-source.
+var changedSources changeSources
+
+// parseChangedSources parses a --changed-files-source value, sources
+// separated by + or commas.
+func parseChangedSources(s string) (changeSources, error) {
+	var sources changeSources
+	for _, name := range strings.FieldsFunc(s, func(r rune) bool { return r == '+' || r == ',' }) {
+		switch strings.TrimSpace(name) {
+		case "worktree":
+			sources.worktree = true
+		case "staged":
+			sources.staged = true
+		case "untracked":
+			sources.untracked = true
+		default:
+			return sources, fmt.Errorf("unknown source %q: use worktree, staged or untracked", name)
+		}
+	}
+	if sources == (changeSources{}) {
+		return sources, errors.New("no source given")
+	}
+	return sources, nil
+}
+
+// selectedSources returns what the run selects as changed files: the
+// --changed-files-source, or what the older flags stand for. --current, and
+// --changed-dirs on its own, are worktree; --staged and --only-staged-hunks
+// are staged.
+func selectedSources() changeSources {
+	switch {
+	case *changedSource != "":
+		return changedSources
+	case *current || *changedDirs && !*staged:
+		return changeSources{worktree: true}
+	case *staged || *onlyStagedHunks:
+		return changeSources{staged: true}
+	}
+	return changeSources{}
+}
+
+// diffRevs returns the git diff arguments that show the tracked changes the
+// sources select, for --check-only-changed-lines.
+func (s changeSources) diffRevs() []string {
+	switch {
+	case s.worktree && s.staged:
+		return []string{"HEAD"}
+	case s.staged:
+		return []string{"--cached"}
+	}
+	return []string{}
+}
+
+// getSourceFiles returns the files the sources select, sorted, each once.
+func getSourceFiles(gitRoot string, sources changeSources) ([]string, error) {
+	var files []string
+	if sources.worktree {
+		changed, err := getChangedFiles(gitRoot)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, changed...)
+	}
+	if sources.staged {
+		staged, err := getStagedFiles(gitRoot)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, staged...)
+	}
+	if sources.untracked {
+		out, err := gitOutput(gitRoot, "ls-files", "-z", "--others", "--exclude-standard")
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range strings.Split(string(out), "\x00") {
+			if file != "" {
+				files = append(files, filepath.Join(gitRoot, file))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return slices.Compact(slices.Sorted(slices.Values(files))), nil
+}
+
+// checkPartiallyStaged looks for staged files that also have unstaged edits.
+// Prettier formats the working tree copy, so restaging such a file would pull
+// the unstaged edits into the commit. This is a warning unless --strict is set,
+// and an error with --check-only-changed-lines, whose line numbers come from
+// the index and would land on the wrong lines of the working tree copy.
+func checkPartiallyStaged(gitRoot string, stagedFiles []string) error {
+	unstaged, err := getChangedFiles(gitRoot)
+	if err != nil {
+		return err
+	}
+
+	dirty := make(map[string]bool, len(unstaged))
+	for _, file := range unstaged {
+		dirty[file] = true
+	}
+
+	var partial []string
+	for _, file := range stagedFiles {
+		if dirty[file] {
+			partial = append(partial, file)
+		}
+	}
+	if len(partial) == 0 {
+		return nil
+	}
+
+	if *strict {
+		return fmt.Errorf("%d staged files also have unstaged changes:\n  %s", len(partial), strings.Join(partial, "\n  "))
+	}
+	if *changedLinesOnly {
+		return fmt.Errorf("--check-only-changed-lines cannot check staged files that also have unstaged changes, since the staged line numbers do not match the working tree:\n  %s", strings.Join(partial, "\n  "))
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %d staged files also have unstaged changes; formatting will include them:\n", len(partial))
+	for _, file := range partial {
+		fmt.Fprintln(os.Stderr, " ", file)
+	}
+	return nil
+}
+
+var defaultExtensions = []string{".js", ".ts", ".json", ".tsx", ".jsx"}
+
+// langExtensions maps the names accepted by --lang to their file extensions.
+var langExtensions = map[string][]string{
+	"javascript": {".js", ".jsx", ".mjs", ".cjs"},
+	"typescript": {".ts", ".tsx", ".mts", ".cts"},
+	"json":       {".json", ".jsonc", ".json5"},
+	"css":        {".css", ".scss", ".less"},
+	"markdown":   {".md", ".markdown", ".mdx"},
+	"yaml":       {".yml", ".yaml"},
+}
+
+// linguistParsers maps the linguist-language values of .gitattributes, in
+// lower case, to the prettier parser for them.
+var linguistParsers = map[string]string{
+	"javascript": "babel",
+	"typescript": "typescript",
+	"json":       "json",
+	"json5":      "json5",
+	"css":        "css",
+	"scss":       "scss",
+	"less":       "less",
+	"markdown":   "markdown",
+	"yaml":       "yaml",
+	"html":       "html",
+	"vue":        "vue",
+	"graphql":    "graphql",
+}
+
+// parserOverrides holds the parser of every file whose linguist-language
+// attribute names a language prettier formats, for --ext-from-gitattributes,
+// and of every file --manifest gives a parser.
+var parserOverrides sync.Map
+
+// parserOverride returns the parser --force-parser, .gitattributes, the
+// --manifest or the config's parsers map assigns file, or "". Of the parsers
+// entries the longest matching extension wins, so .d.ts can differ from .ts.
+func parserOverride(file string) string {
+	if *forceParser != "" {
+		return *forceParser
+	}
+	if parser, ok := parserOverrides.Load(file); ok {
+		return parser.(string)
+	}
+	match := ""
+	for ext := range config.Parsers {
+		if len(ext) > len(match) && strings.HasSuffix(file, ext) {
+			match = ext
+		}
+	}
+	if match != "" {
+		return config.Parsers[match]
+	}
+	return ""
+}
+
+// loadParserOverrides asks git check-attr for the linguist-language of files
+// and records the ones with a known parser in parserOverrides.
+func loadParserOverrides(files []string) error {
+	languages, err := checkAttr("linguist-language", files)
+	if err != nil {
+		return err
+	}
+	for file, language := range languages {
+		if parser, ok := linguistParsers[strings.ToLower(language)]; ok {
+			parserOverrides.Store(file, parser)
+		}
+	}
+	return nil
+}
+
+// readFileList returns the paths listed one per line in name, or on stdin
+// for "-", skipping blank lines. A --check --report-format json report is
+// read too: its needsFormatting files are the list, so a check run's result
+// can be fed straight back in to fix what it found.
+func readFileList(name string) ([]string, error) {
+	data, err := readInput(name)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("{")) {
+		var report struct {
+			NeedsFormatting *[]string `json:"needsFormatting"`
+		}
+		// A list whose first file name starts with { is not JSON.
+		if json.Unmarshal(trimmed, &report) == nil {
+			if report.NeedsFormatting == nil {
+				return nil, errors.New("JSON input is not a pretti report: it has no needsFormatting list")
+			}
+			return *report.NeedsFormatting, nil
+		}
+	}
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// readInput reads the file name, or stdin for "-".
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
+
+// manifestFile is one entry of a --manifest.
+type manifestFile struct {
+	Path string `json:"path"`
+	// Parser, if set, is passed to prettier as --parser for this file.
+	Parser string `json:"parser,omitempty"`
+}
+
+// readManifest returns the paths a --manifest in name ("-" for stdin) lists,
+// recording the parser of each entry that has one in parserOverrides. Paths
+// are relative to the working directory, as with --from-file.
+func readManifest(name string) ([]string, error) {
+	data, err := readInput(name)
+	if err != nil {
+		return nil, err
+	}
+	var m struct {
+		Files []manifestFile `json:"files"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	var files []string
+	for i, f := range m.Files {
+		if f.Path == "" {
+			return nil, fmt.Errorf("files[%d] has no path", i)
+		}
+		if f.Parser != "" {
+			parserOverrides.Store(f.Path, f.Parser)
+		}
+		files = append(files, f.Path)
+	}
+	return files, nil
+}
+
+// gitIgnored returns the files git check-ignore reports as ignored by
+// .gitignore and the other exclude sources git reads. Tracked files are
+// never ignored.
+func gitIgnored(files []string) (map[string]bool, error) {
+	ignored := make(map[string]bool)
+	if len(files) == 0 {
+		return ignored, nil
+	}
+	var input bytes.Buffer
+	for _, file := range files {
+		input.WriteString(file)
+		input.WriteByte(0)
+	}
+	cmd := exec.Command("git", "check-ignore", "-z", "--stdin")
+	cmd.Stdin = &input
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		// Exit status 1 means none of the files are ignored.
+		return ignored, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("git check-ignore failed: %w", err)
+	}
+	for _, file := range strings.Split(string(out), "\x00") {
+		if file != "" {
+			ignored[file] = true
+		}
+	}
+	return ignored, nil
+}
+
+// checkAttr returns the value git check-attr reports for attr on each file,
+// which is "unspecified" for files the attribute is not set on.
+func checkAttr(attr string, files []string) (map[string]string, error) {
+	values := make(map[string]string, len(files))
+	if len(files) == 0 {
+		return values, nil
+	}
+	var input bytes.Buffer
+	for _, file := range files {
+		input.WriteString(file)
+		input.WriteByte(0)
+	}
+	cmd := exec.Command("git", "check-attr", "-z", "--stdin", attr)
+	cmd.Stdin = &input
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git check-attr failed: %w", err)
+	}
+
+	// With -z the output is <path> NUL <attribute> NUL <value> NUL, repeated.
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		values[fields[i]] = fields[i+2]
+	}
+	return values, nil
+}
+
+// presentExtensions returns the extensions in exts that at least one of
+// files ends with, in the order of exts.
+func presentExtensions(exts, files []string) []string {
+	var present []string
+	for _, ext := range exts {
+		for _, file := range files {
+			if strings.HasSuffix(file, ext) {
+				present = append(present, ext)
+				break
+			}
+		}
+	}
+	return present
+}
+
+// resolveExtensions returns the union of the --ext list and the extensions of
+// every --lang language. When neither flag is given it returns the defaults,
+// or with --auto-ext every extension the installed prettier supports. That is
+// also the starting point for --auto-select-ext, which run narrows down to
+// the extensions of the selected files.
+func resolveExtensions() ([]string, error) {
+	if *extList == "" && *langList == "" {
+		// The config's parsers map names extensions to format, so they
+		// are selected too unless --ext or --lang says otherwise.
+		if *autoExt || *autoSelectExt {
+			exts, err := supportedExtensions()
+			return append(exts, sortedKeys(config.Parsers)...), err
+		}
+		return append(slices.Clone(defaultExtensions), sortedKeys(config.Parsers)...), nil
+	}
+
+	var extensions []string
+	seen := make(map[string]bool)
+	add := func(ext string) {
+		if !seen[ext] {
+			seen[ext] = true
+			extensions = append(extensions, ext)
+		}
+	}
+
+	if *extList != "" {
+		for _, ext := range strings.Split(*extList, ",") {
+			add(ext)
+		}
+	}
+	if *langList != "" {
+		for _, lang := range strings.Split(*langList, ",") {
+			exts, ok := langExtensions[strings.ToLower(strings.TrimSpace(lang))]
+			if !ok {
+				return nil, fmt.Errorf("unknown language %q (supported: %s)", lang, strings.Join(sortedKeys(langExtensions), ", "))
+			}
+			for _, ext := range exts {
+				add(ext)
+			}
+		}
+	}
+	return extensions, nil
+}
+
+// expandExtAliases replaces each @name in the comma-separated list with the
+// extensions of the ext_aliases entry of that name, which may refer to other
+// aliases in turn. Duplicates are dropped by resolveExtensions.
+func expandExtAliases(list string) (string, error) {
+	if !strings.Contains(list, "@") {
+		return list, nil
+	}
+	var exts []string
+	var expand func(items []string, seen []string) error
+	expand = func(items []string, seen []string) error {
+		for _, item := range items {
+			name, ok := strings.CutPrefix(strings.TrimSpace(item), "@")
+			if !ok {
+				exts = append(exts, item)
+				continue
+			}
+			if slices.Contains(seen, name) {
+				return fmt.Errorf("extension alias @%s refers to itself", name)
+			}
+			alias, ok := config.ExtAliases[name]
+			if !ok {
+				return fmt.Errorf("unknown extension alias @%s (define it in ext_aliases in %s)", name, configFile)
+			}
+			if err := expand(alias, append(seen, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := expand(strings.Split(list, ","), nil); err != nil {
+		return "", err
+	}
+	return strings.Join(exts, ","), nil
+}
+
+var (
+	supportInfoOnce sync.Once
+	supportInfoExts []string
+	supportInfoErr  error
+)
+
+// checkExtensions returns an error naming every extension in exts that the
+// installed prettier does not support. An empty extension, meaning all
+// files, is always accepted.
+func checkExtensions(exts []string) error {
+	supported, err := supportedExtensions()
+	if err != nil {
+		return err
+	}
+	var unknown []string
+	for _, ext := range exts {
+		if ext != "" && !slices.Contains(supported, ext) {
+			unknown = append(unknown, ext)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("prettier does not support %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// supportedExtensions returns the file extensions reported by
+// prettier --support-info. Prettier is only asked once per run.
+func supportedExtensions() ([]string, error) {
+	supportInfoOnce.Do(func() {
+		// Plugins add languages, so they have to be loaded here too.
+		args := []string{"--support-info"}
+		for _, plugin := range *plugins {
+			args = append(args, "--plugin", plugin)
+		}
+		out, err := prettierCommand(context.Background(), args...).Output()
+		if err != nil {
+			supportInfoErr = fmt.Errorf("prettier --support-info failed: %w", err)
+			return
+		}
+
+		var info struct {
+			Languages []struct {
+				Extensions []string `json:"extensions"`
+			} `json:"languages"`
+		}
+		if err := json.Unmarshal(out, &info); err != nil {
+			supportInfoErr = fmt.Errorf("parsing prettier --support-info: %w", err)
+			return
+		}
+
+		seen := make(map[string]bool)
+		for _, lang := range info.Languages {
+			for _, ext := range lang.Extensions {
+				if !seen[ext] {
+					seen[ext] = true
+					supportInfoExts = append(supportInfoExts, ext)
+				}
+			}
+		}
+	})
+	return supportInfoExts, supportInfoErr
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configFile is the name of pretti's project configuration file, read from
+// the repository root (or the current directory outside a repository).
+const configFile = ".prettirc"
+
+// Config is the content of a config file: the project's .prettirc or the
+// user's global config, both JSON objects.
+type Config struct {
+	// Exclude lists glob patterns applied on every run in addition to
+	// --exclude, e.g. ["*.snap", "fixtures"].
+	Exclude []string `json:"exclude"`
+	// IncludeDirs, when set, limits every run to files under these
+	// directories, given relative to the repository root. Only the
+	// project's .prettirc can set it.
+	IncludeDirs []string `json:"include_dirs"`
+	// ExtAliases names extension groups that --ext can refer to as @name,
+	// e.g. {"web": [".ts", ".tsx", ".css"]}.
+	ExtAliases map[string][]string `json:"ext_aliases"`
+	// Parsers maps extensions to the prettier parser for files that have
+	// them, passed as --parser, e.g. {".jsonc": "json"}.
+	Parsers map[string]string `json:"parsers"`
+	// Flags holds every other key, each a default for the command-line flag
+	// of the same name, e.g. {"jobs": 4, "ext": ".ts,.tsx"}.
+	Flags map[string]json.RawMessage `json:"-"`
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if raw, ok := fields["exclude"]; ok {
+		if err := json.Unmarshal(raw, &c.Exclude); err != nil {
+			return fmt.Errorf("exclude: %w", err)
+		}
+		delete(fields, "exclude")
+	}
+	if raw, ok := fields["include_dirs"]; ok {
+		if err := json.Unmarshal(raw, &c.IncludeDirs); err != nil {
+			return fmt.Errorf("include_dirs: %w", err)
+		}
+		delete(fields, "include_dirs")
+	}
+	if raw, ok := fields["ext_aliases"]; ok {
+		if err := json.Unmarshal(raw, &c.ExtAliases); err != nil {
+			return fmt.Errorf("ext_aliases: %w", err)
+		}
+		delete(fields, "ext_aliases")
+	}
+	if raw, ok := fields["parsers"]; ok {
+		if err := json.Unmarshal(raw, &c.Parsers); err != nil {
+			return fmt.Errorf("parsers: %w", err)
+		}
+		for ext, parser := range c.Parsers {
+			if !strings.HasPrefix(ext, ".") {
+				return fmt.Errorf("parsers: %q is not an extension; start it with a dot", ext)
+			}
+			if parser == "" {
+				return fmt.Errorf("parsers: %s has no parser", ext)
+			}
+		}
+		delete(fields, "parsers")
+	}
+	c.Flags = fields
+	return nil
+}
+
+// config holds the merged excludes and extension aliases of the global config
+// and .prettirc, and the project's include_dirs as absolute paths.
+var config Config
+
+// envPrefix starts the environment variables that set flags, e.g.
+// PRETTI_JOBS=4 for --jobs.
+const envPrefix = "PRETTI_"
+
+// globalConfigPath returns the user's config file,
+// $XDG_CONFIG_HOME/pretti/config.json or ~/.config/pretti/config.json.
+func globalConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "pretti", "config.json")
+}
+
+// projectConfigPath returns the .prettirc at the repository root, or in the
+// current directory outside a repository.
+func projectConfigPath() string {
+	dir, err := getGitRoot()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, configFile)
+}
+
+// loadConfig reads the global config and .prettirc, merging their excludes
+// into config, and applies the flag defaults they and the environment set.
+// Precedence, highest first: command-line flags, PRETTI_* environment
+// variables, .prettirc, the global config, built-in defaults. A repeatable
+// flag takes its values from the highest source that sets it, not from all of
+// them. Missing files are not errors.
+func loadConfig() error {
+	onCommandLine := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+		configSources[f.Name] = "command line"
+	})
+
+	project := projectConfigPath()
+	dir := filepath.Dir(project)
+	// Lowest precedence first, so later files override earlier ones.
+	for _, path := range []string{globalConfigPath(), project} {
+		c, err := readConfig(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		source := "global config " + path
+		if path == project {
+			source = "project config " + path
+		}
+		config.Exclude = append(config.Exclude, c.Exclude...)
+		if path == project {
+			for _, inc := range c.IncludeDirs {
+				abs, err := filepath.Abs(filepath.Join(dir, inc))
+				if err != nil {
+					return fmt.Errorf("%s: include_dirs: %w", path, err)
+				}
+				config.IncludeDirs = append(config.IncludeDirs, abs)
+			}
+		}
+		for _, name := range sortedKeys(c.Flags) {
+			if onCommandLine[name] {
+				continue
+			}
+			resetList(name)
+			if err := setFlagJSON(name, c.Flags[name]); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			configSources[name] = source
+		}
+		if len(c.Exclude) > 0 {
+			configSources["exclude (config)"] = strings.TrimPrefix(configSources["exclude (config)"]+", "+source, ", ")
+		}
+		if path == project && len(c.IncludeDirs) > 0 {
+			configSources["include_dirs"] = source
+		}
+		for name, exts := range c.ExtAliases {
+			// .prettirc redefines an alias the global config has.
+			if config.ExtAliases == nil {
+				config.ExtAliases = make(map[string][]string)
+			}
+			config.ExtAliases[name] = exts
+			configSources["@"+name] = source
+		}
+		for ext, parser := range c.Parsers {
+			if config.Parsers == nil {
+				config.Parsers = make(map[string]string)
+			}
+			config.Parsers[ext] = parser
+			configSources["parsers "+ext] = source
+		}
+	}
+
+	var envErr error
+	flag.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok || onCommandLine[f.Name] || envErr != nil {
+			return
+		}
+		resetList(f.Name)
+		if err := f.Value.Set(value); err != nil {
+			envErr = fmt.Errorf("%s: %w", name, err)
+		}
+		configSources[f.Name] = "environment " + name
+	})
+	return envErr
+}
+
+// configSources records where loadConfig took each flag's value from: the
+// command line, a config file or an environment variable. Flags that are
+// missing kept their built-in default.
+var configSources = map[string]string{}
+
+// writeConfigSources prints every flag's resolved value and where it came
+// from, for --report-config-source, followed by the config-file excludes,
+// include_dirs and extension aliases, which are merged rather than
+// overridden.
+func writeConfigSources(w io.Writer) error {
+	rows := [][3]string{{"OPTION", "VALUE", "SOURCE"}}
+	flag.VisitAll(func(f *flag.Flag) {
+		source, ok := configSources[f.Name]
+		if !ok {
+			source = "default"
+		}
+		rows = append(rows, [3]string{f.Name, f.Value.String(), source})
+	})
+	if len(config.Exclude) > 0 {
+		rows = append(rows, [3]string{"exclude (config)", strings.Join(config.Exclude, ","), configSources["exclude (config)"]})
+	}
+	if len(config.IncludeDirs) > 0 {
+		rows = append(rows, [3]string{"include_dirs", strings.Join(config.IncludeDirs, ","), configSources["include_dirs"]})
+	}
+	for _, name := range sortedKeys(config.ExtAliases) {
+		rows = append(rows, [3]string{"@" + name, strings.Join(config.ExtAliases[name], ","), configSources["@"+name]})
+	}
+	for _, ext := range sortedKeys(config.Parsers) {
+		rows = append(rows, [3]string{"parsers " + ext, config.Parsers[ext], configSources["parsers "+ext]})
+	}
+
+	var width [2]int
+	for _, row := range rows {
+		width[0] = max(width[0], len(row[0]))
+		width[1] = max(width[1], len(row[1]))
+	}
+	for _, row := range rows {
+		if _, err := fmt.Fprintf(w, "%-*s  %-*s  %s\n", width[0], row[0], width[1], row[1], row[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readConfig parses the config file at path. A missing file, or an empty
+// path, gives an empty config.
+func readConfig(path string) (Config, error) {
+	var c Config
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// validateConfig runs the validate-config subcommand: unlike loadConfig,
+// which stops at the first mistake, it checks every key of both config files
+// and every PRETTI_* variable, printing one line per problem to w. It
+// returns 1 if there were any.
+func validateConfig(w io.Writer) int {
+	if *rootDir != "" {
+		if err := enterRoot(*rootDir); err != nil {
+			log.Fatalf("Invalid --root: %v", err)
+		}
+	}
+	var problems, checked []string
+	project := projectConfigPath()
+	for _, path := range []string{globalConfigPath(), project} {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		checked = append(checked, path)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		for _, problem := range configProblems(data, path == project) {
+			problems = append(problems, path+": "+problem)
+		}
+	}
+
+	flags := map[string]bool{}
+	flag.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		flags[name] = true
+		if value, ok := os.LookupEnv(name); ok {
+			if err := f.Value.Set(value); err != nil {
+				problems = append(problems, fmt.Sprintf("environment %s: invalid value %q: %v", name, value, err))
+			}
+		}
+	})
+	var unknown []string
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		// PRETTI_FORMATTED is what pretti passes to --post-format-command.
+		if strings.HasPrefix(name, envPrefix) && !flags[name] && name != "PRETTI_FORMATTED" {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		problems = append(problems, fmt.Sprintf("environment %s: no option of that name", name))
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(w, problem)
+	}
+	switch {
+	case len(problems) > 0:
+		if len(problems) == 1 {
+			fmt.Fprintln(w, "1 problem found")
+		} else {
+			fmt.Fprintf(w, "%d problems found\n", len(problems))
+		}
+		return 1
+	case len(checked) == 0:
+		fmt.Fprintln(w, "No config files found; the environment is valid")
+	default:
+		fmt.Fprintf(w, "Config is valid: %s\n", strings.Join(checked, ", "))
+	}
+	return 0
+}
+
+// configProblems checks the content of a config file key by key, in sorted
+// order, returning a description of each mistake.
+func configProblems(data []byte, project bool) []string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line := 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+			return []string{fmt.Sprintf("line %d: %v", line, err)}
+		}
+		return []string{"the config must be a JSON object"}
+	}
+	var problems []string
+	for _, key := range sortedKeys(fields) {
+		var err error
+		switch key {
+		case "exclude", "include_dirs", "ext_aliases", "parsers":
+			if key == "include_dirs" && !project {
+				err = errors.New("include_dirs: only the project's .prettirc can set it")
+				break
+			}
+			var c Config
+			err = c.UnmarshalJSON([]byte(fmt.Sprintf("{%q: %s}", key, fields[key])))
+		default:
+			err = setFlagJSON(key, fields[key])
+		}
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// setFlagJSON sets the named flag from a config value. Strings are used as
+// they are, arrays set a repeatable flag once per element, and numbers and
+// booleans are used as written.
+func setFlagJSON(name string, raw json.RawMessage) error {
+	f := flag.Lookup(name)
+	if f == nil {
+		return fmt.Errorf("unknown option %q", name)
+	}
+
+	var values []string
+	var text string
+	var list []json.RawMessage
+	switch {
+	case json.Unmarshal(raw, &text) == nil:
+		values = []string{text}
+	case json.Unmarshal(raw, &list) == nil:
+		for _, item := range list {
+			if json.Unmarshal(item, &text) != nil {
+				text = string(item)
+			}
+			values = append(values, text)
+		}
+	default:
+		values = []string{string(raw)}
+	}
+
+	for _, value := range values {
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("%s: invalid value %q: %w", name, value, err)
+		}
+	}
+	return nil
+}
+
+// defaultExcludes are directory names that almost always hold dependencies or
+// generated output. They are skipped unless --no-default-excludes is set.
+var defaultExcludes = []string{"node_modules", "dist", "build", "coverage", ".next", "vendor"}
+
+// excludePatterns returns the active exclude patterns: the defaults, then the
+// project's configured excludes, then anything passed with --exclude.
+func excludePatterns() []string {
+	var patterns []string
+	if !*noDefaultExcludes {
+		patterns = append(patterns, defaultExcludes...)
+	}
+	patterns = append(patterns, config.Exclude...)
+	if *excludeList != "" {
+		patterns = append(patterns, strings.Split(*excludeList, ",")...)
+	}
+	return patterns
+}
+
+// isExcluded reports whether rel, a slash-separated path relative to the
+// selection root, matches an exclude pattern. A pattern matches if it matches
+// the whole path or any single path element, so "dist" excludes every file
+// under any dist directory and "*.snap" excludes snapshot files anywhere.
+func isExcluded(rel string, patterns []string) bool {
+	parts := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		for _, part := range parts {
+			if ok, _ := path.Match(pattern, part); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// relPath returns file relative to root in slash form, falling back to file
+// itself when it is not under root.
+func relPath(root, file string) string {
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return filepath.ToSlash(file)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// walkFiles returns the files under root that pass filterFiles, skipping .git
+// and excluded directories without descending into them. Each top-level
+// directory is walked and filtered on its own goroutine, at most
+// --walk-concurrency at a time, and the merged result is sorted.
+func walkFiles(root string, exts []string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var ignored *ignoreMatcher
+	if !*noIgnore {
+		if ignored, err = newIgnoreMatcher(root); err != nil {
+			return nil, err
+		}
+	}
+	filters, err := selectionFilters(exts, root, ignored)
+	if err != nil {
+		return nil, err
+	}
+	patterns := excludePatterns()
+	var topFiles, topDirs []string
+	for _, entry := range entries {
+		p := filepath.Join(root, entry.Name())
+		if dir, ok := symlinkedDir(p, entry); ok {
+			if !skipDir(root, p, patterns, ignored) {
+				topDirs = append(topDirs, dir)
+			}
+		} else if !entry.IsDir() {
+			topFiles = append(topFiles, p)
+		} else if !skipDir(root, p, patterns, ignored) {
+			topDirs = append(topDirs, p)
+		}
+	}
+
+	type walkResult struct {
+		files []string
+		err   error
+	}
+	results := make(chan walkResult)
+	sem := make(chan struct{}, *walkConcurrency)
+	var wg sync.WaitGroup
+	for _, dir := range topDirs {
+		wg.Add(1)
+		go func(dir string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			files, err := walkDir(root, dir, patterns, ignored)
+			if err != nil {
+				results <- walkResult{err: err}
+				return
+			}
+			files, err = filterFiles(files, filters)
+			results <- walkResult{files: files, err: err}
+		}(dir)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	files, err := filterFiles(topFiles, filters)
+	for result := range results {
+		if result.err != nil && err == nil {
+			err = result.err
+		}
+		files = append(files, result.files...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// walkDir returns every file under dir, which is itself below root, skipping
+// the directories skipDir rules out. Symlinked directories are only walked
+// with --resolve-symlinks, through the directory they resolve to and each at
+// most once, so a link cycle ends.
+func walkDir(root, dir string, patterns []string, ignored *ignoreMatcher) ([]string, error) {
+	return walkTree(root, dir, patterns, ignored, make(map[string]bool))
+}
+
+func walkTree(root, dir string, patterns []string, ignored *ignoreMatcher, seen map[string]bool) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if target, ok := symlinkedDir(p, d); ok {
+			if seen[target] || skipDir(root, p, patterns, ignored) {
+				return nil
+			}
+			seen[target] = true
+			linked, err := walkTree(root, target, patterns, ignored, seen)
+			files = append(files, linked...)
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && skipDir(root, p, patterns, ignored) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	return files, err
+}
+
+// symlinkedDir returns the directory p, a symlink entry of the walk,
+// resolves to when --resolve-symlinks is set.
+func symlinkedDir(p string, d fs.DirEntry) (string, bool) {
+	if !*resolveSymlinks || d.Type()&fs.ModeSymlink == 0 {
+		return "", false
+	}
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", false
+	}
+	if info, err := os.Stat(target); err != nil || !info.IsDir() {
+		return "", false
+	}
+	return target, true
+}
+
+// ignoreMatcher applies the ignore files to the --all walk the way git
+// applies .gitignore: the .gitignore in every directory from the repository
+// root (root itself outside a repository) down covers the paths below it, a
+// deeper file's rules win over a shallower one's, and the repository's
+// info/exclude comes below them all. A path inside an ignored directory stays
+// ignored. The .prettierignore in root, which prettier reads, ignores paths
+// as well. A nil matcher ignores nothing.
+type ignoreMatcher struct {
+	// root is the walk root as the walk names it; the other paths are
+	// absolute.
+	root string
+	// base is the directory the .gitignore files are read from down, and
+	// prefix the path elements from base to root.
+	base   string
+	prefix []string
+	// exclude are the info/exclude rules.
+	exclude        []ignoreRule
+	prettierignore []ignoreRule
+
+	mu sync.Mutex
+	// gitignores caches the rules of each directory's .gitignore by its
+	// slash-separated path relative to base, "" for base itself.
+	gitignores map[string][]ignoreRule
+}
+
+// newIgnoreMatcher reads the ignore files that apply to the walk of root.
+// Those of root and the directories above it are read now, so an unreadable
+// one is an error; those further down are read as the walk reaches them, and
+// one that cannot be read ignores nothing.
+func newIgnoreMatcher(root string) (*ignoreMatcher, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return nil, err
+	}
+	m := &ignoreMatcher{root: root, base: abs, gitignores: make(map[string][]ignoreRule)}
+	if gitRoot, err := getGitRoot(); err == nil {
+		if gitRoot, err = filepath.EvalSymlinks(gitRoot); err == nil {
+			if rel := relPath(gitRoot, abs); rel != ".." && !strings.HasPrefix(rel, "../") {
+				m.base = gitRoot
+				if rel != "." {
+					m.prefix = strings.Split(rel, "/")
+				}
+			}
+		}
+		// info/exclude is shared by all worktrees, so it is in the common
+		// git dir rather than getGitDir's.
+		if out, err := exec.Command("git", "rev-parse", "--git-path", "info/exclude").Output(); err == nil {
+			if m.exclude, err = readIgnoreRules(strings.TrimSpace(string(out))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if m.prettierignore, err = readIgnoreRules(filepath.Join(abs, ".prettierignore")); err != nil {
+		return nil, err
+	}
+	for i := 0; i <= len(m.prefix); i++ {
+		dir := strings.Join(m.prefix[:i], "/")
+		rules, err := readIgnoreRules(filepath.Join(m.base, filepath.FromSlash(dir), ".gitignore"))
+		if err != nil {
+			return nil, err
+		}
+		m.gitignores[dir] = rules
+	}
+	return m, nil
+}
+
+// readIgnoreRules parses the gitignore-style file name. A missing file has no
+// rules.
+func readIgnoreRules(name string) ([]ignoreRule, error) {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseIgnoreRules(string(data)), nil
+}
+
+// gitignore returns the rules of the .gitignore in dir, a slash-separated
+// path relative to base.
+func (m *ignoreMatcher) gitignore(dir string) []ignoreRule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rules, ok := m.gitignores[dir]
+	if !ok {
+		rules, _ = readIgnoreRules(filepath.Join(m.base, filepath.FromSlash(dir), ".gitignore"))
+		m.gitignores[dir] = rules
+	}
+	return rules
+}
+
+// ignored reports whether p, a path the walk of root found, is ignored.
+// Paths outside root, which a symlink can lead to, are not.
+func (m *ignoreMatcher) ignored(p string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	rel := relPath(m.root, p)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") || filepath.IsAbs(rel) {
+		return false
+	}
+	if ignoredBy(m.prettierignore, rel, isDir) {
+		return true
+	}
+	elems := append(slices.Clip(m.prefix), strings.Split(rel, "/")...)
+	for i := len(m.prefix) + 1; i < len(elems); i++ {
+		if m.decide(elems[:i], true) {
+			return true
+		}
+	}
+	return m.decide(elems, isDir)
+}
+
+// decide applies info/exclude and then the .gitignore of each directory
+// above elems, a path relative to base, from the top down, so the last one
+// with a matching rule decides.
+func (m *ignoreMatcher) decide(elems []string, isDir bool) bool {
+	ignored, _ := matchRules(m.exclude, elems, isDir)
+	for d := range elems {
+		if ig, matched := matchRules(m.gitignore(strings.Join(elems[:d], "/")), elems[d:], isDir); matched {
+			ignored = ig
+		}
+	}
+	return ignored
+}
+
+// prettiIgnoreFile lists paths pretti never selects, in gitignore form. It is
+// read from the repository root (or the current directory outside a
+// repository), and unlike .prettierignore prettier itself does not read it.
+const prettiIgnoreFile = ".prettiignore"
+
+// loadPrettiIgnore returns the directory .prettiignore was looked for in and
+// the rules it lists. A missing file has no rules.
+func loadPrettiIgnore() (string, []ignoreRule, error) {
+	base, err := getGitRoot()
+	if err != nil {
+		if base, err = os.Getwd(); err != nil {
+			return "", nil, err
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(base, prettiIgnoreFile))
+	if os.IsNotExist(err) {
+		return base, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return base, parseIgnoreRules(string(data)), nil
+}
+
+// codeownersFiles are the places GitHub looks for a CODEOWNERS file, relative
+// to the repository root, in the order it looks; the first one found is used.
+var codeownersFiles = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// codeownersRule is one line of a CODEOWNERS file.
+type codeownersRule struct {
+	segments []string
+	// dirOnly is set for a pattern/, which only matches what is inside
+	// the directory.
+	dirOnly bool
+	// childrenOnly is set for a pattern ending in /*, which matches the
+	// files directly in the directory but not those further down.
+	childrenOnly bool
+	// owners may be empty, which leaves the matching files without an
+	// owner.
+	owners []string
+}
+
+// loadCodeowners returns the repository root (the current directory outside a
+// repository), the CODEOWNERS file found below it and its rules. The file is
+// "" when there is none.
+func loadCodeowners() (string, string, []codeownersRule, error) {
+	base, err := getGitRoot()
+	if err != nil {
+		if base, err = os.Getwd(); err != nil {
+			return "", "", nil, err
+		}
+	}
+	for _, name := range codeownersFiles {
+		data, err := os.ReadFile(filepath.Join(base, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", "", nil, err
+		}
+		return base, name, parseCodeowners(string(data)), nil
+	}
+	return base, "", nil, nil
+}
+
+// parseCodeowners parses a CODEOWNERS file: each line is a pattern followed by
+// its owners, up to a # comment. GitHub has no !pattern, so none is
+// recognized here.
+func parseCodeowners(data string) []codeownersRule {
+	var rules []codeownersRule
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		pattern := strings.Replace(fields[0], "\\#", "#", 1)
+		var rule codeownersRule
+		for _, owner := range fields[1:] {
+			if strings.HasPrefix(owner, "#") {
+				break
+			}
+			rule.owners = append(rule.owners, owner)
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		if pattern == "" {
+			continue
+		}
+		rule.segments = patternSegments(pattern)
+		rule.childrenOnly = len(rule.segments) > 1 && rule.segments[len(rule.segments)-1] == "*"
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// codeownersOf returns the owners of rel, a slash-separated path relative to
+// the repository root. As on GitHub the last rule that matches decides, and a
+// rule that matches a directory owns everything below it.
+func codeownersOf(rules []codeownersRule, rel string) []string {
+	elems := strings.Split(rel, "/")
+	var owners []string
+	for _, rule := range rules {
+		if rule.matches(elems) {
+			owners = rule.owners
+		}
+	}
+	return owners
+}
+
+func (r codeownersRule) matches(elems []string) bool {
+	if !r.dirOnly && matchSegments(r.segments, elems) {
+		return true
+	}
+	if r.childrenOnly {
+		return false
+	}
+	for i := 1; i < len(elems); i++ {
+		if matchSegments(r.segments, elems[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOwner reports whether two CODEOWNERS owners are the same, ignoring case
+// (GitHub does) and the @ in front of a user or team.
+func sameOwner(a, b string) bool {
+	return strings.EqualFold(strings.TrimPrefix(a, "@"), strings.TrimPrefix(b, "@"))
+}
+
+// codeownersFilter keeps the files rules, read from base, an absolute
+// directory, assign to owner.
+func codeownersFilter(base string, rules []codeownersRule, owner string) Filter {
+	return func(path string, _ os.FileInfo) bool {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return false
+		}
+		for _, o := range codeownersOf(rules, relPath(base, abs)) {
+			if sameOwner(o, owner) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ignoreRule is one pattern of a gitignore-style file, evaluated the way git
+// does by ignoredBy.
+type ignoreRule struct {
+	// segments is the pattern split on "/". A pattern without a slash
+	// other than a trailing one matches at any depth, so it starts with
+	// a ** segment.
+	segments []string
+	// negate is set for a !pattern, which re-includes what an earlier
+	// pattern ignored.
+	negate bool
+	// dirOnly is set for a pattern/ that only matches directories.
+	dirOnly bool
+}
+
+// parseIgnoreRules parses the content of a gitignore-style file, keeping the
+// order of its patterns. Blank lines and # comments are skipped, trailing
+// spaces are dropped unless escaped with a backslash, and \# and \! start
+// patterns that begin with those characters.
+func parseIgnoreRules(data string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\#") || strings.HasPrefix(line, "\\!") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.segments = patternSegments(line)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// patternSegments splits a gitignore-style pattern, without its trailing
+// slash, for matchSegments. A slash at the start or in the middle anchors the
+// pattern to the directory of the file it was read from; without one it
+// matches at any depth.
+func patternSegments(pattern string) []string {
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+}
+
+// ignoredBy reports whether rel, a slash-separated path relative to the
+// directory of the ignore file, is ignored by rules: the last rule that
+// matches it decides, so a later !pattern re-includes it. As in git, a path
+// below an ignored directory stays ignored whatever the later rules say, since
+// git never looks inside that directory.
+func ignoredBy(rules []ignoreRule, rel string, isDir bool) bool {
+	elems := strings.Split(rel, "/")
+	for i := 1; i < len(elems); i++ {
+		if lastMatch(rules, elems[:i], true) {
+			return true
+		}
+	}
+	return lastMatch(rules, elems, isDir)
+}
+
+// lastMatch reports whether the last of rules to match elems ignores them,
+// or false when none matches.
+func lastMatch(rules []ignoreRule, elems []string, isDir bool) bool {
+	ignored, _ := matchRules(rules, elems, isDir)
+	return ignored
+}
+
+// matchRules is lastMatch, also reporting whether any rule matched at all.
+func matchRules(rules []ignoreRule, elems []string, isDir bool) (ignored, matched bool) {
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(rule.segments, elems) {
+			ignored, matched = !rule.negate, true
+		}
+	}
+	return ignored, matched
+}
+
+// prettiIgnoreFilter drops files that rules, read from base, an absolute
+// directory, ignore. Unlike excludeFilter it accepts files given relative to
+// the working directory, which may be below base.
+func prettiIgnoreFilter(base string, rules []ignoreRule) Filter {
+	return func(path string, _ os.FileInfo) bool {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return true
+		}
+		return !ignoredBy(rules, relPath(base, abs), false)
+	}
+}
+
+// skipDir reports whether the walk should not descend into dir: .git,
+// excluded and ignored directories, the --backup and --preview-dir
+// directories, other git repositories nested below root unless
+// --include-nested-repos is set, and with --max-depth N anything more than N
+// levels below root, so 0 keeps only root's own files.
+func skipDir(root, dir string, patterns []string, ignored *ignoreMatcher) bool {
+	rel := relPath(root, dir)
+	if filepath.Base(dir) == ".git" || isExcluded(rel, patterns) || ignored.ignored(dir, true) {
+		return true
+	}
+	if *backupDir != "" && sameFile(dir, *backupDir) || *previewDir != "" && sameFile(dir, *previewDir) {
+		return true
+	}
+	if !*includeNestedRepos {
+		// .git is a directory in a clone and a file in a submodule or
+		// worktree; either way the files belong to another repository.
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return true
+		}
+	}
+	return *maxDepth >= 0 && strings.Count(rel, "/")+1 > *maxDepth
+}
+
+// modifiedCutoff is the time set by --modified-after. Files last modified
+// before it are dropped by modifiedAfterFilter; the zero value keeps
+// everything.
+var modifiedCutoff time.Time
+
+// contentPattern is the compiled --content-match, or nil.
+var contentPattern *regexp.Regexp
+
+// parseCutoff parses a --modified-after value: either a duration counted
+// back from now, such as 720h, or a date in YYYY-MM-DD or RFC 3339 form.
+func parseCutoff(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a duration (e.g. 720h) or a date (YYYY-MM-DD or RFC 3339)", value)
+}
+
+// Filter reports whether a candidate file should be kept. info is nil when
+// the file exists but could not be stat'ed.
+type Filter func(path string, info os.FileInfo) bool
+
+// selectionFilter is a Filter with the reason reported for the files it
+// drops.
+type selectionFilter struct {
+	reason string
+	// source names the flag or file the filter comes from, for
+	// --dump-selection-tree.
+	source string
+	keep   Filter
+}
+
+// Reasons a candidate file was skipped, as reported by --report-skipped-reasons.
+const (
+	skipMissing    = "missing"
+	skipOutOfScope = "out-of-scope"
+	skipExcluded   = "excluded"
+	skipIgnored    = "ignored"
+	skipNotOwned   = "not-owned"
+	skipExtension  = "other-extension"
+	skipOld        = "not-modified-recently"
+	skipNoMatch    = "no-content-match"
+	skipEmpty      = "empty"
+	skipSymlink    = "symlink"
+	skipOutside    = "outside-repository"
+	skipConflicted = "conflict-markers"
+)
+
+// selectionFilters returns the filters every selected file must pass, in the
+// order they are applied. ignored applies the ignore files for the --all walk;
+// other modes pass nil. The .prettiignore patterns apply in every mode, after
+// them.
+func selectionFilters(exts []string, root string, ignored *ignoreMatcher) ([]selectionFilter, error) {
+	var filters []selectionFilter
+	if !*resolveSymlinks {
+		filters = append(filters, selectionFilter{skipSymlink, "--resolve-symlinks", symlinkFilter})
+	}
+	if len(config.IncludeDirs) > 0 {
+		filters = append(filters, selectionFilter{skipOutOfScope, "include_dirs", includeDirsFilter(config.IncludeDirs)})
+	}
+	filters = append(filters, selectionFilter{skipExcluded, "--exclude", excludeFilter(root, excludePatterns())})
+	if ignored != nil {
+		filters = append(filters, selectionFilter{skipIgnored, ".gitignore/.prettierignore", func(path string, _ os.FileInfo) bool {
+			return !ignored.ignored(path, false)
+		}})
+	}
+	base, rules, err := loadPrettiIgnore()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", prettiIgnoreFile, err)
+	}
+	if len(rules) > 0 {
+		filters = append(filters, selectionFilter{skipIgnored, prettiIgnoreFile, prettiIgnoreFilter(base, rules)})
+	}
+	filters = append(filters,
+		selectionFilter{skipExtension, "--ext", extFilter(exts)},
+		selectionFilter{skipOld, "--modified-after", modifiedAfterFilter(modifiedCutoff)},
+	)
+	if *codeowners != "" {
+		base, name, rules, err := loadCodeowners()
+		if err != nil {
+			return nil, fmt.Errorf("reading CODEOWNERS: %w", err)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("--codeowners: no CODEOWNERS file in %s", strings.Join(codeownersFiles, ", "))
+		}
+		filters = append(filters, selectionFilter{skipNotOwned, name, codeownersFilter(base, rules, *codeowners)})
+	}
+	if *skipEmptyFiles && !*formatEmptyFiles {
+		filters = append(filters, selectionFilter{skipEmpty, "--skip-empty-files", nonEmptyFilter})
+	}
+	if *headRev == "" {
+		// With --head the files are read from a commit, not the working
+		// tree this would look at.
+		filters = append(filters, selectionFilter{skipConflicted, "conflict markers", conflictFilter})
+	}
+	if contentPattern != nil {
+		// Last, so only the files every cheaper filter kept are read.
+		filters = append(filters, selectionFilter{skipNoMatch, "--content-match", contentFilter(contentPattern)})
+	}
+	return filters, nil
+}
+
+// binarySniffLen is how much of a file contentFilter looks at for a NUL
+// byte, the same heuristic git uses to call a file binary.
+const binarySniffLen = 8000
+
+// openFileSlots bounds how many files the content filters have open at once
+// to --max-open-files, so a parallel walk of a huge tree does not run out of
+// file descriptors. It is nil until run sets it up, and reads go unbounded.
+var openFileSlots chan struct{}
+
+// readScanned reads path for a content filter, holding one of the
+// openFileSlots while the file is open.
+func readScanned(path string) ([]byte, error) {
+	if openFileSlots != nil {
+		openFileSlots <- struct{}{}
+		defer func() { <-openFileSlots }()
+	}
+	return os.ReadFile(path)
+}
+
+// contentFilter keeps files whose content matches re. Binary files and files
+// that cannot be read are dropped.
+func contentFilter(re *regexp.Regexp) Filter {
+	return func(path string, info os.FileInfo) bool {
+		content, err := readScanned(path)
+		if err != nil || bytes.IndexByte(content[:min(len(content), binarySniffLen)], 0) >= 0 {
+			return false
+		}
+		return re.Match(content)
+	}
+}
+
+// conflictFilter drops files that still have merge conflict markers: a
+// <<<<<<< line followed later by a >>>>>>> line, as git writes them. A
+// ======= line alone is not enough, since it underlines Markdown headings.
+// Prettier would reflow the markers into the code and leave the file
+// impossible to resolve. Binary files and files that cannot be read are kept,
+// for prettier to report.
+func conflictFilter(path string, _ os.FileInfo) bool {
+	content, err := readScanned(path)
+	if err != nil || bytes.IndexByte(content[:min(len(content), binarySniffLen)], 0) >= 0 {
+		return true
+	}
+	opened := false
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if isConflictMarker(line, '<') {
+			opened = true
+		} else if opened && isConflictMarker(line, '>') {
+			return false
+		}
+	}
+	return true
+}
+
+// isConflictMarker reports whether line is a conflict marker of seven c
+// characters, alone or followed by a space and a label.
+func isConflictMarker(line []byte, c byte) bool {
+	marker := bytes.Repeat([]byte{c}, 7)
+	rest, ok := bytes.CutPrefix(line, marker)
+	return ok && (len(rest) == 0 || rest[0] == ' ')
+}
+
+// symlinkFilter drops symlinks. Formatting one would write its target, which
+// may be outside the repository or selected a second time under its own
+// name.
+func symlinkFilter(path string, _ os.FileInfo) bool {
+	info, err := os.Lstat(path)
+	return err != nil || info.Mode()&fs.ModeSymlink == 0
+}
+
+// resolveSymlinkPaths replaces each file with the path it resolves to, for
+// --resolve-symlinks, dropping the ones that resolve outside the repository
+// (the current directory outside one) and any already listed. Absolute paths
+// stay absolute and relative ones relative to the working directory.
+func resolveSymlinkPaths(files []string) ([]string, error) {
+	base, err := getGitRoot()
+	if err != nil {
+		if base, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+	if base, err = filepath.EvalSymlinks(base); err != nil {
+		return nil, err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if wd, err = filepath.EvalSymlinks(wd); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var resolved []string
+	for _, file := range files {
+		real, err := filepath.EvalSymlinks(file)
+		if err != nil {
+			// Left for prettier to report, like any other unreadable file.
+			real = file
+		}
+		if real, err = filepath.Abs(real); err != nil {
+			real = file
+		}
+		if rel, err := filepath.Rel(base, real); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			skipped.add(skipOutside, file)
+			decisions.add(file, skipOutside)
+			continue
+		}
+		if seen[real] {
+			continue
+		}
+		seen[real] = true
+		if !filepath.IsAbs(file) {
+			if rel, err := filepath.Rel(wd, real); err == nil {
+				real = rel
+			}
+		}
+		resolved = append(resolved, real)
+	}
+	return resolved, nil
+}
+
+// nonEmptyFilter drops zero-length files. Prettier leaves them alone anyway,
+// but some plugins fail on them.
+func nonEmptyFilter(path string, info os.FileInfo) bool {
+	return info == nil || !info.Mode().IsRegular() || info.Size() > 0
+}
+
+// modifiedAfterFilter drops files last modified before cutoff.
+func modifiedAfterFilter(cutoff time.Time) Filter {
+	return func(path string, info os.FileInfo) bool {
+		return info == nil || !info.ModTime().Before(cutoff)
+	}
+}
+
+// includeDirsFilter keeps only files under one of dirs, which are absolute.
+func includeDirsFilter(dirs []string) Filter {
+	return func(path string, info os.FileInfo) bool {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return false
+		}
+		for _, dir := range dirs {
+			if abs == dir || strings.HasPrefix(abs, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// excludeFilter drops files whose path relative to root matches patterns.
+func excludeFilter(root string, patterns []string) Filter {
+	return func(path string, info os.FileInfo) bool {
+		return !isExcluded(relPath(root, path), patterns)
+	}
+}
+
+// extFilter keeps files with one of exts, and files .gitattributes gives a
+// parser for. An empty extension keeps every file.
+func extFilter(exts []string) Filter {
+	return func(path string, info os.FileInfo) bool {
+		// --force-parser does not widen the selection; only .gitattributes
+		// picks out files by name.
+		if _, ok := parserOverrides.Load(path); ok {
+			return true
+		}
+		for _, ext := range exts {
+			if ext == "" || strings.HasSuffix(path, ext) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// filterFiles returns the files that exist and pass every filter, and
+// records the rest in skipped.
+func filterFiles(files []string, filters []selectionFilter) ([]string, error) {
+	if *extFromGitattributes {
+		if err := loadParserOverrides(files); err != nil {
+			return nil, fmt.Errorf("reading .gitattributes: %w", err)
+		}
+	}
+	var filtered []string
+	for _, file := range files {
+		var info os.FileInfo
+		var err error
+		if *headRev == "" {
+			// With --head the files are read from a commit, so whether
+			// they are on disk does not matter.
+			info, err = os.Stat(file)
+		}
+		if os.IsNotExist(err) {
+			skipped.add(skipMissing, file)
+			decisions.add(file, skipMissing)
+			continue
+		}
+		if f := rejectingFilter(filters, file, info); f != nil {
+			skipped.add(f.reason, file)
+			decisions.add(file, fmt.Sprintf("%s (%s)", f.reason, f.source))
+			continue
+		}
+		filtered = append(filtered, file)
+		decisions.add(file, "")
+		if info != nil && (*schedule == "largest-first" || *progressMode == "bytes") {
+			fileSizes.add(file, info.Size())
+		}
+	}
+	return filtered, nil
+}
+
+// sizeLog keeps the size of each selected file from the stat filterFiles
+// already did, for --schedule largest-first and --progress bytes.
+type sizeLog struct {
+	mu    sync.Mutex
+	sizes map[string]int64
+}
+
+var fileSizes sizeLog
+
+func (l *sizeLog) add(file string, size int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sizes == nil {
+		l.sizes = make(map[string]int64)
+	}
+	l.sizes[file] = size
+}
+
+// size returns the size of file, stat'ing it if the selection did not. A
+// file that cannot be stat'ed counts as empty.
+func (l *sizeLog) size(file string) int64 {
+	l.mu.Lock()
+	size, ok := l.sizes[file]
+	l.mu.Unlock()
+	if ok {
+		return size
+	}
+	if info, err := os.Stat(file); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+// decisionLog records, for --dump-selection-tree, why each candidate file
+// was kept or dropped. An empty reason means it was kept.
+type decisionLog struct {
+	mu      sync.Mutex
+	reasons map[string]string
+}
+
+var decisions decisionLog
+
+func (l *decisionLog) add(file, reason string) {
+	if !*dumpSelection && !*printIgnored {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.reasons == nil {
+		l.reasons = make(map[string]string)
+	}
+	l.reasons[file] = reason
+}
+
+// reason returns the reason recorded for file.
+func (l *decisionLog) reason(file string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reasons[file]
+}
+
+// dump prints every recorded file under its directory, in path order, as
+// kept or dropped with the filter that dropped it.
+func (l *decisionLog) dump(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	files := slices.SortedFunc(maps.Keys(l.reasons), func(a, b string) int {
+		return cmp.Or(strings.Compare(filepath.Dir(a), filepath.Dir(b)), strings.Compare(a, b))
+	})
+	fmt.Fprintf(w, "Selection (%d candidates):\n", len(files))
+	lastDir := ""
+	for _, file := range files {
+		if dir := filepath.Dir(file); dir != lastDir {
+			fmt.Fprintf(w, "%s/\n", filepath.ToSlash(dir))
+			lastDir = dir
+		}
+		if reason := l.reasons[file]; reason == "" {
+			fmt.Fprintf(w, "  kept     %s\n", filepath.Base(file))
+		} else {
+			fmt.Fprintf(w, "  dropped  %s: %s\n", filepath.Base(file), reason)
+		}
+	}
+}
+
+// rejectingFilter returns the first filter path fails, or nil if it passes
+// them all.
+func rejectingFilter(filters []selectionFilter, path string, info os.FileInfo) *selectionFilter {
+	for i := range filters {
+		if !filters[i].keep(path, info) {
+			return &filters[i]
+		}
+	}
+	return nil
+}
+
+// skipLog collects the files the selection filters dropped, by reason. The
+// --all walk filters from several goroutines at once.
+type skipLog struct {
+	mu    sync.Mutex
+	files map[string][]string
+}
+
+var skipped skipLog
+
+func (l *skipLog) add(reason, file string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.files == nil {
+		l.files = make(map[string][]string)
+	}
+	l.files[reason] = append(l.files[reason], file)
+}
+
+// byReason returns the skipped files by reason, each list sorted.
+func (l *skipLog) byReason() map[string][]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, files := range l.files {
+		sort.Strings(files)
+	}
+	return l.files
+}
+
+// errorLog records the error prettier reported for each file it failed on.
+type errorLog struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+var fileErrors errorLog
+
+func (l *errorLog) add(file, message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.files == nil {
+		l.files = make(map[string]string)
+	}
+	l.files[file] = message
+}
+
+// message returns the error recorded for file, if any.
+func (l *errorLog) message(file string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	message, ok := l.files[file]
+	return message, ok
+}
+
+func (l *errorLog) remove(file string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.files, file)
+}
+
+// byFile returns the recorded errors by file.
+func (l *errorLog) byFile() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.files
+}
+
+func runPrettier(files []string) error {
+	return runBatches(files, func(ctx context.Context, _ int, b batch, stderr io.Writer) error {
+		line := writeCommandLine("--write", b)
+		cmd := exec.CommandContext(ctx, line[0], line[1:]...)
+		cmd.Stdout = prettierStdout()
+		cmd.Stderr = stderr
+		if *jsonLines {
+			cmd.Stdout = &lineWriter{fn: streamWriteLine}
+			cmd.Stderr = io.MultiWriter(stderr, &lineWriter{fn: streamErrorLine})
+		}
+
+		defer stats.recordBatch(b.files, time.Now())
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return &prettierExitError{code: exitErr.ExitCode()}
+			}
+			return fmt.Errorf("failed to run prettier: %w (make sure it's installed and in PATH)", err)
+		}
+		journal.add(b.files)
+		return nil
+	})
+}
+
+// maxBatchFiles caps how many paths go into one prettier invocation so huge
+// selections stay well below the OS argument length limit.
+const maxBatchFiles = 200
+
+// batch is the share of the files one prettier invocation gets.
+type batch struct {
+	files []string
+	// parser is passed as --parser for files whose type comes from
+	// .gitattributes rather than their extension.
+	parser string
+}
+
+// paths returns what goes in place of the file list in prettierArgs.
+func (b batch) paths() []string {
+	if b.parser == "" {
+		return b.files
+	}
+	return append([]string{"--parser", b.parser}, b.files...)
+}
+
+// parserGroups groups files by their parser override, keeping the order of
+// files within each group. Files without an override come first.
+func parserGroups(files []string) []batch {
+	groups := []batch{{}}
+	index := map[string]int{"": 0}
+	for _, file := range files {
+		parser := parserOverride(file)
+		i, ok := index[parser]
+		if !ok {
+			i = len(groups)
+			index[parser] = i
+			groups = append(groups, batch{parser: parser})
+		}
+		groups[i].files = append(groups[i].files, file)
+	}
+	if len(groups[0].files) == 0 {
+		groups = groups[1:]
+	}
+	return groups
+}
+
+// splitBatches splits files into batches of at most maxBatchFiles that share
+// a parser, using at least as many batches as there are --jobs so every
+// worker has something to do. With --per-file-timeout every file is a batch
+// of its own, and --batch-size sets the size outright.
+func splitBatches(files []string) []batch {
+	size := (len(files) + *jobs - 1) / *jobs
+	size = max(1, min(size, maxBatchFiles))
+	if *perFileTimeout > 0 {
+		size = 1
+	} else if *batchSize > 0 {
+		size = *batchSize
+	}
+	var batches []batch
+	for _, group := range parserGroups(files) {
+		if *schedule == "largest-first" {
+			batches = append(batches, balancedBatches(group, size)...)
+			continue
+		}
+		rest := group.files
+		for len(rest) > 0 {
+			n := min(size, len(rest))
+			batches = append(batches, batch{files: rest[:n], parser: group.parser})
+			rest = rest[n:]
+		}
+	}
+	if *schedule == "largest-first" {
+		// Workers take batches in order, so the biggest ones start first
+		// and the small ones fill in at the end.
+		slices.SortStableFunc(batches, func(a, b batch) int {
+			return cmp.Compare(batchBytes(b), batchBytes(a))
+		})
+	}
+	return batches
+}
+
+// balancedBatches splits group into as many batches of at most size files as
+// splitBatches would, for --schedule largest-first: taking the files from the
+// largest down, each goes to the batch with the fewest bytes so far that has
+// room, so no batch is left with most of the big files. Within a batch the
+// largest file comes first.
+func balancedBatches(group batch, size int) []batch {
+	files := slices.Clone(group.files)
+	slices.SortStableFunc(files, func(a, b string) int {
+		return cmp.Compare(fileSizes.size(b), fileSizes.size(a))
+	})
+	batches := make([]batch, (len(files)+size-1)/size)
+	totals := make([]int64, len(batches))
+	for _, file := range files {
+		best := -1
+		for i := range batches {
+			if len(batches[i].files) < size && (best < 0 || totals[i] < totals[best]) {
+				best = i
+			}
+		}
+		batches[best].files = append(batches[best].files, file)
+		totals[best] += fileSizes.size(file)
+	}
+	for i := range batches {
+		batches[i].parser = group.parser
+	}
+	return batches
+}
+
+// batchBytes returns the total size of the files in b.
+func batchBytes(b batch) int64 {
+	var total int64
+	for _, file := range b.files {
+		total += fileSizes.size(file)
+	}
+	return total
+}
+
+// prettierProcessMB is a rough estimate of the memory one prettier process
+// needs, used by --max-memory-mb. Node alone takes about 50 MB; prettier and
+// a batch of files being parsed add the rest.
+const prettierProcessMB = 150
+
+// memoryLimitedJobs returns how many prettier processes fit in limitMB next
+// to pretti itself, at least 1 and at most jobs, and the MB left for them.
+// On Linux the memory the system reports as available also caps it, so a
+// runner that is already short does not start more than it can hold.
+func memoryLimitedJobs(jobs, limitMB int) (int, int) {
+	budget := limitMB
+	if rss, ok := procMemoryMB("/proc/self/status", "VmRSS"); ok {
+		budget -= rss
+	} else {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		budget -= int(m.Sys >> 20)
+	}
+	if available, ok := procMemoryMB("/proc/meminfo", "MemAvailable"); ok && available < budget {
+		budget = available
+	}
+	return min(max(budget/prettierProcessMB, 1), jobs), max(budget, 0)
+}
+
+// procMemoryMB reads a "Key:   1234 kB" line from a Linux /proc file such as
+// /proc/meminfo, in MB. It reports false on other systems or when the key is
+// missing.
+func procMemoryMB(name, key string) (int, bool) {
+	if runtime.GOOS != "linux" {
+		return 0, false
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		value, ok := strings.CutPrefix(line, key+":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) != 2 || fields[1] != "kB" {
+			return 0, false
+		}
+		kb, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return 0, false
+		}
+		return kb >> 10, true
+	}
+	return 0, false
+}
+
+// runBatches calls fn for every batch of files on up to --jobs workers.
+// Failed batches do not stop the others, and the first failure is returned
+// once all batches have run. With --fail-fast the first failure cancels the
+// context passed to fn, which kills in-flight prettier processes, and any
+// batches that have not started yet are skipped. fn should send prettier's
+// stderr to the writer it is given, which counts the files prettier reports
+// errors for so that --max-errors can stop the run the same way.
+func runBatches(files []string, fn func(ctx context.Context, i int, b batch, stderr io.Writer) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	budget := &errorBudget{max: int64(*maxErrors), cancel: cancel}
+	stderr := io.MultiWriter(prettierStderr(), &lineWriter{fn: budget.line})
+
+	var mu sync.Mutex
+	var first error
+	batches := splitBatches(files)
+	meter := newProgressMeter(files)
+	defer meter.finish()
+	parallel(len(batches), *jobs, func(i int) {
+		if ctx.Err() != nil {
+			return
+		}
+		if *timeBudget > 0 && time.Since(runStart) > *timeBudget {
+			outOfTime.add(batches[i].files)
+			return
+		}
+		start := time.Now()
+		events.emitBatch("file-start", i, batches[i], 0)
+		err := runBatch(ctx, i, batches[i], stderr, fn)
+		events.emitBatch("file-done", i, batches[i], time.Since(start))
+		meter.advance(batches[i])
+		if err == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if first != nil {
+			// With --fail-fast this is a batch we canceled ourselves.
+			return
+		}
+		first = err
+		if *failFast {
+			cancel()
+		}
+	})
+	if budget.exceeded.Load() {
+		// The batch that hit the cap may have been killed by it, so its
+		// exit status says nothing; report it as prettier's error exit.
+		return fmt.Errorf("stopped after %d files failed (--max-errors): %w", budget.max, &prettierExitError{code: 2})
+	}
+	return first
+}
+
+// runBatch calls fn for one batch. With --per-file-timeout, where a batch is
+// a single file, it kills prettier once the timeout passes and reports the
+// file on stderr in prettier's own error format, so it counts towards
+// --max-errors and shows up as errored in reports.
+func runBatch(ctx context.Context, i int, b batch, stderr io.Writer, fn func(ctx context.Context, i int, b batch, stderr io.Writer) error) error {
+	if *perFileTimeout <= 0 {
+		return fn(ctx, i, b, stderr)
+	}
+	fileCtx, cancel := context.WithTimeout(ctx, *perFileTimeout)
+	defer cancel()
+	err := fn(fileCtx, i, b, stderr)
+	if err != nil && errors.Is(fileCtx.Err(), context.DeadlineExceeded) {
+		fmt.Fprintf(stderr, "[error] %s: timed out after %s (--per-file-timeout)\n", b.files[0], *perFileTimeout)
+		return &prettierExitError{code: 2}
+	}
+	return err
+}
+
+// progressMeter prints --progress to stderr: redrawn in place on a terminal,
+// one line per finished batch elsewhere. A nil meter prints nothing.
+type progressMeter struct {
+	mu       sync.Mutex
+	bytes    bool
+	terminal bool
+	files    int
+	done     int
+	total    int64
+	finished int64
+}
+
+// newProgressMeter returns the meter for a run over files, or nil without
+// --progress.
+func newProgressMeter(files []string) *progressMeter {
+	if *progressMode == "" || len(files) == 0 {
+		return nil
+	}
+	m := &progressMeter{bytes: *progressMode == "bytes", terminal: isTerminal(os.Stderr), files: len(files)}
+	for _, file := range files {
+		m.total += m.weight(file)
+	}
+	return m
+}
+
+// weight is how much file counts towards the total.
+func (m *progressMeter) weight(file string) int64 {
+	if m.bytes {
+		return fileSizes.size(file)
+	}
+	return 1
+}
+
+// advance counts b as finished, whether prettier succeeded on it or not.
+func (m *progressMeter) advance(b batch) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.done += len(b.files)
+	for _, file := range b.files {
+		m.finished += m.weight(file)
+	}
+	fraction := 1.0
+	if m.total > 0 {
+		fraction = float64(m.finished) / float64(m.total)
+	}
+	const width = 20
+	filled := int(fraction * width)
+	line := fmt.Sprintf("[%s%s] %3d%% %d/%d files", strings.Repeat("#", filled), strings.Repeat("-", width-filled), int(fraction*100), m.done, m.files)
+	if m.bytes {
+		line += fmt.Sprintf(", %s of %s", formatSize(m.finished), formatSize(m.total))
+	}
+	if m.terminal {
+		fmt.Fprintf(os.Stderr, "\r%s\x1b[K", line)
+	} else {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// finish ends the line a terminal meter redraws.
+func (m *progressMeter) finish() {
+	if m == nil || !m.terminal {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done > 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// formatSize formats a byte count for people, e.g. 512 B or 12.3 MB.
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size := float64(n) / 1024
+	for _, unit := range []string{"kB", "MB", "GB"} {
+		if size < 1024 || unit == "GB" {
+			return fmt.Sprintf("%.1f %s", size, unit)
+		}
+		size /= 1024
+	}
+	panic("unreachable")
+}
+
+// runStart is when pretti started, which --time-budget counts from.
+var runStart = time.Now()
+
+// outOfTime collects the files that were not processed because the
+// --time-budget ran out before their batch started.
+var outOfTime fileList
+
+// fileList is a list of files that is safe to append to concurrently.
+type fileList struct {
+	mu    sync.Mutex
+	files []string
+}
+
+func (l *fileList) add(files []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files = append(l.files, files...)
+}
+
+// sorted returns the files in order, without duplicates.
+func (l *fileList) sorted() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Compact(slices.Sorted(slices.Values(l.files)))
+}
+
+// errorBudget counts the files prettier reports errors for and cancels the
+// run once --max-errors of them have failed. A max of 0 means no limit.
+type errorBudget struct {
+	max      int64
+	count    atomic.Int64
+	exceeded atomic.Bool
+	cancel   context.CancelFunc
+}
+
+// line is called for every line prettier writes to stderr. Errors about a
+// file look like "[error] src/a.ts: SyntaxError: ..." or, for a file prettier
+// has no parser for, "[error] No parser could be inferred for file "x"."
+func (b *errorBudget) line(line string) {
+	rest, ok := strings.CutPrefix(line, "[error] ")
+	if !ok {
+		return
+	}
+	var path, message string
+	if p, ok := strings.CutPrefix(rest, `No parser could be inferred for file "`); ok {
+		path, message = strings.TrimSuffix(p, `".`), rest
+	} else if path, message, ok = strings.Cut(rest, ": "); !ok {
+		return
+	}
+	fileErrors.add(path, message)
+	if n := b.count.Add(1); b.max > 0 && n >= b.max {
+		b.exceeded.Store(true)
+		b.cancel()
+	}
+}
+
+// events is where --emit-events writes, or nil.
+var events *eventLog
+
+// eventLog writes --emit-events lifecycle events, one JSON object per line.
+// Batches run in parallel, so events from different batches interleave but
+// never mix. Its methods do nothing on a nil log.
+type eventLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+	err error
+}
+
+// openEvents opens an --emit-events target: fd:N for a file descriptor the
+// caller left open, such as 3, or else a file, which is truncated.
+func openEvents(target string) (*eventLog, error) {
+	if n, ok := strings.CutPrefix(target, "fd:"); ok {
+		fd, err := strconv.Atoi(n)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("%q is not a file descriptor", n)
+		}
+		f := os.NewFile(uintptr(fd), target)
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("file descriptor %d is not open", fd)
+		}
+		return &eventLog{f: f, enc: json.NewEncoder(f)}, nil
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// eventHeader starts every event. Like the --json-lines fields, the event
+// fields are part of pretti's output contract.
+type eventHeader struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+}
+
+func newEvent(name string) eventHeader {
+	return eventHeader{Event: name, Time: time.Now()}
+}
+
+type runStartEvent struct {
+	eventHeader
+	Mode  string `json:"mode"` // "write" or "check"
+	Files int    `json:"files"`
+	Jobs  int    `json:"jobs"`
+}
+
+// fileEvent is a file-start or file-done event. Prettier gets its files in
+// batches, so every file of a batch starts and is done together.
+type fileEvent struct {
+	eventHeader
+	Path  string `json:"path"`
+	Batch int    `json:"batch"`
+	// Status and the rest are only set on file-done: "ok", or "error"
+	// with prettier's message.
+	Status     string  `json:"status,omitempty"`
+	Message    string  `json:"message,omitempty"`
+	DurationMs float64 `json:"durationMs,omitempty"`
+}
+
+type runEndEvent struct {
+	eventHeader
+	ExitCode        int     `json:"exitCode"`
+	DurationMs      float64 `json:"durationMs"`
+	Formatted       int     `json:"formatted"`
+	Unchanged       int     `json:"unchanged"`
+	NeedsFormatting int     `json:"needsFormatting"`
+	Errored         int     `json:"errored"`
+	Skipped         int     `json:"skipped"`
+}
+
+// newRunEndEvent sums up the run res, nil when it ended before any file was
+// processed, that exits with code.
+func newRunEndEvent(res *Result, code int) runEndEvent {
+	e := runEndEvent{eventHeader: newEvent("run-end"), ExitCode: code, DurationMs: millis(time.Since(runStart))}
+	if res != nil {
+		e.Formatted, e.Unchanged, e.NeedsFormatting = len(res.Formatted), len(res.Unchanged), len(res.NeedsFormatting)
+	}
+	e.Errored = len(fileErrors.byFile())
+	for _, files := range skipped.byReason() {
+		e.Skipped += len(files)
+	}
+	return e
+}
+
+func (l *eventLog) emit(e any) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(e); err != nil && l.err == nil {
+		l.err = err
+	}
+}
+
+// emitBatch emits a file event for each file of batch i. A file-done event
+// took d.
+func (l *eventLog) emitBatch(name string, i int, b batch, d time.Duration) {
+	if l == nil {
+		return
+	}
+	for _, file := range b.files {
+		e := fileEvent{eventHeader: newEvent(name), Path: file, Batch: i}
+		if name == "file-done" {
+			e.Status, e.DurationMs = "ok", millis(d)
+			if message, ok := fileErrors.message(file); ok {
+				e.Status, e.Message = "error", message
+			}
+		}
+		l.emit(e)
+	}
+}
+
+// close closes the event file and returns the first error writing to it.
+func (l *eventLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.f.Close(); err != nil && l.err == nil {
+		l.err = err
+	}
+	return l.err
+}
+
+// jsonLine is one --json-lines record, written as soon as prettier reports on
+// a file. Its field names are part of pretti's output contract.
+type jsonLine struct {
+	Path       string  `json:"path"`
+	Status     string  `json:"status"` // "formatted", "unchanged" or "error"
+	DurationMs float64 `json:"durationMs,omitempty"`
+	Message    string  `json:"message,omitempty"`
+}
+
+var jsonLinesMu sync.Mutex
+
+// streamJSONLine writes one --json-lines record to stdout. Batches run in
+// parallel, so records from different batches interleave but never mix.
+func streamJSONLine(rec jsonLine) {
+	jsonLinesMu.Lock()
+	defer jsonLinesMu.Unlock()
+	json.NewEncoder(os.Stdout).Encode(rec)
+}
+
+// streamWriteLine turns a line prettier --write prints for each file, e.g.
+// "src/a.ts 12ms" or "src/a.ts 3ms (unchanged)", into a --json-lines record.
+func streamWriteLine(line string) {
+	rec := jsonLine{Status: "formatted"}
+	var path []string
+	for _, field := range strings.Fields(line) {
+		if field == "(unchanged)" {
+			rec.Status = "unchanged"
+		} else if d, err := time.ParseDuration(field); err == nil && strings.HasSuffix(field, "s") {
+			rec.DurationMs = millis(d)
+		} else {
+			path = append(path, field)
+		}
+	}
+	if len(path) == 0 {
+		return
+	}
+	rec.Path = strings.Join(path, " ")
+	streamJSONLine(rec)
+}
+
+// streamErrorLine turns a prettier "[error] <file>: <message>" line into a
+// --json-lines record. Other stderr output is left alone.
+func streamErrorLine(line string) {
+	rest, ok := strings.CutPrefix(line, "[error] ")
+	if !ok {
+		return
+	}
+	path, message, ok := strings.Cut(rest, ": ")
+	if !ok {
+		return
+	}
+	streamJSONLine(jsonLine{Path: path, Status: "error", Message: message})
+}
+
+// lineWriter calls fn for every complete line written to it.
+type lineWriter struct {
+	buf []byte
+	fn  func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.fn(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// prettierExitError reports that prettier ran but exited with a non-zero
+// status. Prettier exits 1 when check mode finds unformatted files and 2 when
+// something went wrong, and pretti passes the code through unchanged.
+type prettierExitError struct {
+	code int
+}
+
+func (e *prettierExitError) Error() string {
+	return fmt.Sprintf("prettier exited with code %d", e.code)
+}
+
+// failureLog logs the prettier failures pretti carries on after. It is not the
+// standard logger, whose writes startProfile treats as fatal.
+var failureLog = log.New(os.Stderr, "", log.LstdFlags)
+
+// prettierFailed logs a failed prettier run and returns the exit code pretti
+// should use: prettier's own code, or 2 if prettier could not be started.
+func prettierFailed(context string, err error) int {
+	prettierLog.flush(os.Stderr)
+	failureLog.Printf("%s: %v", context, err)
+	var exitErr *prettierExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return 2
+}
+
+// terminalStdout is the process's stdout, which --quiet does not hold back
+// for prettier.
+var terminalStdout = os.Stdout
+
+// prettierStdout returns where prettier's own output should go. It is moved
+// to stderr when a machine-readable report is printed on stdout, and held in
+// prettierLog with --quiet-prettier.
+func prettierStdout() io.Writer {
+	if *quietPrettier {
+		return &prettierLog
+	}
+	if machineReport() && *outputFile == "" {
+		return os.Stderr
+	}
+	return terminalStdout
+}
+
+// prettierStderr returns where prettier's stderr should go.
+func prettierStderr() io.Writer {
+	if *quietPrettier {
+		return &prettierLog
+	}
+	return os.Stderr
+}
+
+// prettierLog holds prettier's stdout and stderr, in the order prettier
+// wrote them, under --quiet-prettier. prettierFailed prints it.
+var prettierLog syncBuffer
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent writes.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// flush writes what the buffer holds to w and empties it.
+func (b *syncBuffer) flush(w io.Writer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.WriteTo(w)
+}
+
+// checkPrettier runs prettier in --list-different mode and returns the files
+// it reports as not formatted. Prettier exits 1 when it finds differences,
+// which is the expected outcome here rather than an error. When prettier
+// fails on some files, the ones it did report are returned with the error.
+func checkPrettier(files []string) ([]string, error) {
+	found := make([][]string, len(files))
+	err := runBatches(files, func(ctx context.Context, i int, b batch, stderr io.Writer) error {
+		cmd := prettierCommand(ctx, prettierArgs("--list-different", b.paths())...)
+		cmd.Stderr = stderr
+
+		start := time.Now()
+		out, err := cmd.Output()
+		stats.recordBatch(b.files, start)
+		for _, file := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if file == "" {
+				continue
+			}
+			found[i] = append(found[i], file)
+		}
+		if err != nil {
+			exitErr, ok := err.(*exec.ExitError)
+			if !ok {
+				return fmt.Errorf("failed to run prettier: %w (make sure it's installed and in PATH)", err)
+			}
+			if exitErr.ExitCode() != 1 {
+				return &prettierExitError{code: exitErr.ExitCode()}
+			}
+		}
+		return nil
+	})
+
+	var unformatted []string
+	for _, batch := range found {
+		unformatted = append(unformatted, batch...)
+	}
+	return unformatted, err
+}
+
+// checkBlobs is checkPrettier for --head: it formats each file's content at
+// rev, read with git show, and returns the files prettier would change. The
+// working tree is not read, so rev need not be checked out.
+func checkBlobs(gitRoot, rev string, files []string) ([]string, error) {
+	changed := make([]bool, len(files))
+	errs := make([]error, len(files))
+	parallel(len(files), *jobs, func(i int) {
+		content, err := gitOutput(gitRoot, "show", rev+":"+relPath(gitRoot, files[i]))
+		if err != nil {
+			errs[i] = fmt.Errorf("%s: %w", files[i], err)
+			return
+		}
+		formatted, err := formatStdin(content, files[i])
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		changed[i] = !bytes.Equal(content, formatted)
+	})
+
+	var unformatted []string
+	for i, file := range files {
+		if changed[i] {
+			unformatted = append(unformatted, file)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return unformatted, err
+		}
+	}
+	return unformatted, nil
+}
+
+// Result is the outcome of a run. Every report format is produced from it.
+// The JSON field names are part of pretti's output contract.
+type Result struct {
+	// Mode is "write" or "check".
+	Mode string `json:"mode"`
+	// Files are all files that were passed to prettier.
+	Files []string `json:"files"`
+	// Formatted are the files whose content prettier changed (write mode).
+	Formatted []string `json:"formatted"`
+	// Unchanged are the files prettier left as they were (write mode).
+	Unchanged []string `json:"unchanged"`
+	// NeedsFormatting are the files prettier reported as unformatted (check mode).
+	NeedsFormatting []string `json:"needsFormatting"`
+	// Skipped are the candidate files left out of the selection, by reason
+	// (only with --report-skipped-reasons).
+	Skipped map[string][]string `json:"skipped,omitempty"`
+	// LineEndings are the files --normalize-line-endings converted before
+	// prettier ran. They are listed separately from Formatted.
+	LineEndings []string `json:"lineEndingsNormalized,omitempty"`
+	// OutOfTime are the files left unprocessed when --time-budget ran out.
+	OutOfTime []string `json:"outOfTime,omitempty"`
+	// Errored maps the files prettier failed on to its error message (only in
+	// the JUnit report of a failed run).
+	Errored map[string]string `json:"errored,omitempty"`
+	// DiffStats has, for each file that needs formatting, how many lines
+	// prettier would add and remove (only with --report-diff-stats).
+	DiffStats map[string]DiffStat `json:"diffStats,omitempty"`
+}
+
+// DiffStat counts the lines a minimal line diff adds and removes.
+type DiffStat struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// DryRun is the --dry-run report in --report-format json. Unlike Result it
+// describes a run that has not happened.
+type DryRun struct {
+	// DryRun is always true, telling the document apart from a Result.
+	DryRun bool   `json:"dryRun"`
+	Mode   string `json:"mode"`
+	// Files are the files that would be passed to prettier.
+	Files []string `json:"files"`
+	// Commands are the command lines that would run, one argument per
+	// element, before they are split into --jobs batches.
+	Commands [][]string `json:"commands"`
+	// WouldChange are the files prettier would change (only with
+	// --dry-run-check).
+	WouldChange []string `json:"wouldChange,omitempty"`
+	// Skipped are the candidate files left out of the selection, by reason
+	// (only with --report-skipped-reasons).
+	Skipped map[string][]string `json:"skipped,omitempty"`
+}
+
+// writeDryRun writes the --dry-run report for files as JSON and returns the
+// exit code: 1 if --dry-run-check found files that would change.
+func writeDryRun(files []string) int {
+	report := DryRun{DryRun: true, Mode: "write", Files: files, Commands: [][]string{}}
+	if checkMode() {
+		report.Mode = "check"
+	}
+	if report.Files == nil {
+		report.Files = []string{}
+	}
+	for _, b := range parserGroups(files) {
+		report.Commands = append(report.Commands, writeCommandLine(modeFlag(), b))
+	}
+	if *dryRunCheck && len(files) > 0 {
+		unformatted, err := checkPrettier(files)
+		if err != nil {
+			return prettierFailed("Error checking files", err)
+		}
+		report.WouldChange = unformatted
+	}
+	if *reportSkipped {
+		report.Skipped = skipped.byReason()
+	}
+	w, err := openReport()
+	if err != nil {
+		log.Fatalf("Error opening report file: %v", err)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(report)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatalf("Error writing report: %v", err)
+	}
+	if len(report.WouldChange) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// flagWasSet reports whether the named flag was set on the command line, by
+// a config file or by the environment. It goes by configSources, since
+// flag.Visit only sees the command line: loadConfig sets the others directly.
+func flagWasSet(name string) bool {
+	_, set := configSources[name]
+	return set
+}
+
+// reportFormats are the accepted --report-format values.
+var reportFormats = []string{"text", "json", "sarif", "github", "junit"}
+
+// writeCollapsed writes res as the single --collapse-output line, e.g.
+// "formatted=12 unchanged=30 errored=0 skipped=3". In check mode the counts
+// are of files that need formatting and files that are already formatted.
+func writeCollapsed(w io.Writer, res Result) error {
+	_, err := fmt.Fprintln(w, statusCounts(res))
+	return err
+}
+
+// statusCounts formats the counts of res as key=value pairs for
+// --collapse-output and --count-by-status.
+func statusCounts(res Result) string {
+	var skippedCount int
+	for _, files := range res.Skipped {
+		skippedCount += len(files)
+	}
+	errored := len(res.Errored)
+	if res.Mode == "check" {
+		return fmt.Sprintf("unformatted=%d formatted=%d errored=%d skipped=%d",
+			len(res.NeedsFormatting), len(res.Files)-len(res.NeedsFormatting)-errored, errored, skippedCount)
+	}
+	return fmt.Sprintf("formatted=%d unchanged=%d errored=%d skipped=%d",
+		len(res.Formatted), len(res.Unchanged), errored, skippedCount)
+}
+
+// writeStatusLine writes the --count-by-status line for a run that exits
+// with code. The errored and skipped counts cover the whole run, whether or
+// not res recorded them.
+func writeStatusLine(w io.Writer, res *Result, code int) error {
+	var r Result
+	if res != nil {
+		r = *res
+	}
+	if r.Mode == "" && checkMode() {
+		r.Mode = "check"
+	}
+	r.Errored = fileErrors.byFile()
+	r.Skipped = skipped.byReason()
+	_, err := fmt.Fprintf(w, "pretti: %s exit=%d\n", statusCounts(r), code)
+	return err
+}
+
+// trackChanges reports whether a write run has to hash the files before and
+// after prettier to tell which ones it changed.
+func trackChanges() bool {
+	return *reportFormat == "json" || *collapseOutput || *countByStatus || *summaryOnChange || *print0 || events != nil || postFormatArgs != nil
+}
+
+// changedNothing reports whether the write run res left every file as it
+// was, for --summary-only-on-change. Files cut by --time-budget count as
+// news too.
+func changedNothing(res Result) bool {
+	return res.Mode == "write" && len(res.Formatted) == 0 && len(res.NeedsFormatting) == 0 &&
+		len(res.LineEndings) == 0 && len(res.OutOfTime) == 0
+}
+
+// machineReport reports whether the report format is meant for programs
+// only, so nothing else may be mixed into its output.
+func machineReport() bool {
+	switch *reportFormat {
+	case "json", "sarif", "junit":
+		return true
+	}
+	return false
+}
+
+// writeReport prints res in the selected report format and returns exit
+// status 1 if any file needs formatting.
+func writeReport(res Result) int {
+	w, err := openReport()
+	if err != nil {
+		log.Fatalf("Error opening report file: %v", err)
+	}
+
+	switch {
+	case *jsonLines:
+		// Every file was already reported as it completed.
+	case *summaryOnChange && changedNothing(res):
+		// The files were already clean, so there is nothing to say.
+	case *print0:
+		err = writeNullList(w, res)
+	case *reportFormat == "sarif":
+		err = writeSARIF(w, res.NeedsFormatting)
+	case *reportFormat == "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(res)
+	case *reportFormat == "junit":
+		err = writeJUnit(w, res)
+	case *reportFormat == "github":
+		if err = writeAnnotations(w, res.NeedsFormatting); err == nil {
+			err = writeText(w, res)
+		}
+	default:
+		err = writeText(w, res)
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatalf("Error writing report: %v", err)
+	}
+
+	if len(res.NeedsFormatting) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// writeText writes the human-readable summary of res, followed by the
+// skipped files when they were recorded.
+func writeText(w io.Writer, res Result) error {
+	if *collapseOutput {
+		return writeCollapsed(w, res)
+	}
+	var err error
+	switch {
+	case res.Mode == "check" && len(res.NeedsFormatting) == 0:
+		_, err = fmt.Fprintf(w, "All %d files are formatted\n", len(res.Files))
+	case res.Mode == "check" && res.DiffStats != nil:
+		var added, removed int
+		notes := make(map[string]string, len(res.DiffStats))
+		for file, stat := range res.DiffStats {
+			added += stat.Added
+			removed += stat.Removed
+			notes[file] = fmt.Sprintf("| +%d -%d", stat.Added, stat.Removed)
+		}
+		fmt.Fprintf(w, "%d files need formatting, %d insertions(+), %d deletions(-):\n", len(res.NeedsFormatting), added, removed)
+		printFileListNotes(w, res.NeedsFormatting, notes)
+	case res.Mode == "check":
+		fmt.Fprintf(w, "%d files need formatting:\n", len(res.NeedsFormatting))
+		printFileList(w, res.NeedsFormatting)
+	case len(res.NeedsFormatting) > 0:
+		fmt.Fprintf(w, "%d files still need formatting after writing them:\n", len(res.NeedsFormatting))
+		printFileList(w, res.NeedsFormatting)
+	case *formatModifiedOnly && len(res.Formatted) == 0:
+		_, err = fmt.Fprintf(w, "All %d files are already formatted\n", len(res.Files))
+	case *formatModifiedOnly:
+		fmt.Fprintf(w, "Successfully formatted %d files:\n", len(res.Formatted))
+		printFileList(w, res.Formatted)
+		_, err = fmt.Fprintf(w, "Skipped %d files that were already formatted\n", len(res.Unchanged))
+	default:
+		fmt.Fprintf(w, "Successfully formatted %d files:\n", len(res.Files))
+		printFileList(w, res.Files)
+	}
+	if err == nil && len(res.LineEndings) > 0 {
+		_, err = fmt.Fprintf(w, "Normalized the line endings of %d files before formatting\n", len(res.LineEndings))
+	}
+	if err == nil && len(res.OutOfTime) > 0 {
+		_, err = fmt.Fprintf(w, "Stopped when the --time-budget ran out; %d files were not processed\n", len(res.OutOfTime))
+	}
+	if err == nil && res.Skipped != nil {
+		err = printSkipped(w, res.Skipped)
+	}
+	if err == nil && *printIgnored {
+		err = printIgnoredFiles(w)
+	}
+	return err
+}
+
+// printIgnoredFiles lists the files an ignore file made pretti skip, for
+// --print-skipped-ignored, each with the filter that matched it, e.g.
+// "src/gen.ts (.prettiignore)".
+func printIgnoredFiles(w io.Writer) error {
+	files := skipped.byReason()[skipIgnored]
+	if _, err := fmt.Fprintf(w, "Skipped %d ignored files\n", len(files)); err != nil {
+		return err
+	}
+	for _, file := range files {
+		source := strings.TrimPrefix(decisions.reason(file), skipIgnored+" ")
+		if _, err := fmt.Fprintf(w, "  %s %s\n", file, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipReasons lists the skip reasons in the order the filters apply them.
+var skipReasons = []string{skipMissing, skipSymlink, skipOutOfScope, skipExcluded, skipIgnored, skipExtension, skipOld, skipNotOwned, skipEmpty, skipConflicted, skipNoMatch, skipOutside}
+
+// printSkipped prints how many files were skipped for each reason, e.g.
+// "Skipped 6 files: 2 missing, 3 ignored, 1 other-extension", and with
+// --verbose the files themselves.
+func printSkipped(w io.Writer, byReason map[string][]string) error {
+	var total int
+	var counts []string
+	for _, reason := range skipReasons {
+		if n := len(byReason[reason]); n > 0 {
+			total += n
+			counts = append(counts, fmt.Sprintf("%d %s", n, reason))
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "Skipped %d files: %s\n", total, strings.Join(counts, ", ")); err != nil {
+		return err
+	}
+	if *verbose {
+		for _, reason := range skipReasons {
+			for _, file := range byReason[reason] {
+				fmt.Fprintf(w, "  %s (%s)\n", file, reason)
+			}
+		}
+	}
+	return nil
+}
+
+// warnLargeSelectionCount warns, for --warn-on-large-selection, that n files
+// are about to be formatted or checked. It stands out from prettier's output
+// but does not stop the run; --confirm-threshold is the way to be asked.
+func warnLargeSelectionCount(w io.Writer, n int) {
+	rule := strings.Repeat("!", 72)
+	fmt.Fprintln(w, rule)
+	fmt.Fprintf(w, "Warning: %d files are selected, more than --warn-on-large-selection %d.\n", n, *warnLargeSelection)
+	fmt.Fprintln(w, "Run pretti list with the same flags to see them if this is broader than you meant.")
+	fmt.Fprintln(w, rule)
+}
+
+// writeNullList writes, for --print0, the files prettier changed, or in check
+// mode the files that need formatting, each followed by a NUL byte so that
+// any file name survives, e.g. for xargs -0.
+func writeNullList(w io.Writer, res Result) error {
+	files := res.Formatted
+	if res.Mode == "check" || len(res.NeedsFormatting) > 0 {
+		files = res.NeedsFormatting
+	}
+	for _, file := range files {
+		if _, err := fmt.Fprintf(w, "%s\x00", displayPath(file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// displayPath returns file as pretti prints it: relative to --relative-to
+// when that is set, or as it was selected.
+func displayPath(file string) string {
+	if *relativeTo == "" {
+		return file
+	}
+	return filepath.FromSlash(reportPath(*relativeTo, file))
+}
+
+// printFileList prints one indented file per line, or with --group-by-dir a
+// header per directory followed by the base names of its files. With
+// --limit-output-lines N only the first N files are listed, followed by a
+// line saying how many were left out.
+func printFileList(w io.Writer, files []string) {
+	printFileListNotes(w, files, nil)
+}
+
+// printFileListNotes is printFileList with notes[file], where there is one,
+// printed after the file's name.
+func printFileListNotes(w io.Writer, files []string, notes map[string]string) {
+	if *relativeTo != "" || notes != nil {
+		files = slices.Clone(files)
+		for i, file := range files {
+			files[i] = displayPath(file)
+			if note, ok := notes[file]; ok {
+				files[i] += " " + note
+			}
+		}
+	}
+	if *limitOutputLines > 0 && len(files) > *limitOutputLines {
+		defer fmt.Fprintf(w, "  ... and %d more\n", len(files)-*limitOutputLines)
+	}
+	if !*groupByDir {
+		if *limitOutputLines > 0 {
+			files = files[:min(len(files), *limitOutputLines)]
+		}
+		for _, file := range files {
+			fmt.Fprintln(w, " ", file)
+		}
+		return
+	}
+
+	var dirs []string
+	byDir := make(map[string][]string)
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], filepath.Base(file))
+	}
+	sort.Strings(dirs)
+	left := *limitOutputLines
+	for _, dir := range dirs {
+		names := byDir[dir]
+		if *limitOutputLines > 0 {
+			if left == 0 {
+				break
+			}
+			names = names[:min(len(names), left)]
+			left -= len(names)
+		}
+		fmt.Fprintf(w, "  %s/\n", filepath.ToSlash(dir))
+		for _, name := range names {
+			fmt.Fprintln(w, "   ", name)
+		}
+	}
+}
+
+// backupFiles copies files into the --backup directory before prettier
+// writes them, at their path relative to root. Files outside root keep their
+// absolute path below the backup directory. It does nothing without --backup.
+func backupFiles(root string, files []string) error {
+	if *backupDir == "" {
+		return nil
+	}
+	for _, file := range files {
+		dst, err := mirrorPath(*backupDir, root, file)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(file, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mirrorPath returns where file goes below dir: at its path relative to root,
+// or at its absolute path when it is outside root.
+func mirrorPath(dir, root, file string) (string, error) {
+	rel := relPath(root, file)
+	if rel == ".." || strings.HasPrefix(rel, "../") || filepath.IsAbs(rel) {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return "", err
+		}
+		rel = strings.TrimPrefix(filepath.ToSlash(strings.TrimPrefix(abs, filepath.VolumeName(abs))), "/")
+	}
+	return filepath.Join(dir, filepath.FromSlash(rel)), nil
+}
+
+// previewFiles writes prettier's formatting of each file into the
+// --preview-dir directory, mirrored the same way as --backup, and leaves the
+// files themselves alone. Prettier's errors go to stderr.
+func previewFiles(root string, files []string) error {
+	errs := make([]error, len(files))
+	parallel(len(files), *jobs, func(i int) {
+		errs[i] = previewFile(root, files[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func previewFile(root, file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	formatted, err := formatStdin(content, file)
+	if err != nil {
+		return err
+	}
+	dst, err := mirrorPath(*previewDir, root, file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, formatted, 0o644)
+}
+
+// diffToolArgs is the parsed --diff-tool command, or nil.
+var diffToolArgs []string
+
+// resolveDiffTool parses a --diff-tool command. "git" hands the files to git
+// difftool, so the tool git config diff.tool names is the one that opens,
+// with its difftool.<tool>.cmd if it has one.
+func resolveDiffTool(tool string) ([]string, error) {
+	if tool != "git" {
+		return splitArgs(tool)
+	}
+	out, err := exec.Command("git", "config", "--get", "diff.tool").Output()
+	if err != nil || strings.TrimSpace(string(out)) == "" {
+		return nil, errors.New("git config diff.tool is not set")
+	}
+	return []string{"git", "difftool", "--no-prompt", "--no-index"}, nil
+}
+
+// reviewInDiffTool formats files into a temporary directory, mirrored like
+// --preview-dir so the copies keep their names, and opens each file
+// prettier would change in the --diff-tool, one after the other, as
+// "<tool> <file> <formatted copy>". It returns how many files it opened. The
+// tool's exit status is ignored, since many exit non-zero when the files
+// differ.
+func reviewInDiffTool(root string, files []string) (int, error) {
+	dir, err := os.MkdirTemp("", "pretti-diff-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	copies := make([]string, len(files))
+	errs := make([]error, len(files))
+	parallel(len(files), *jobs, func(i int) {
+		copies[i], errs[i] = formattedCopy(dir, root, files[i])
+	})
+	if err := errors.Join(errs...); err != nil {
+		return 0, err
+	}
+	reviewed := 0
+	for i, file := range files {
+		if copies[i] == "" {
+			continue
+		}
+		args := append(slices.Clone(diffToolArgs[1:]), file, copies[i])
+		cmd := exec.Command(diffToolArgs[0], args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				return reviewed, fmt.Errorf("failed to run the diff tool: %w", err)
+			}
+		}
+		reviewed++
+	}
+	return reviewed, nil
+}
+
+// formattedCopy writes prettier's formatting of file under dir and returns
+// the copy's path, or "" if prettier leaves the file as it is.
+func formattedCopy(dir, root, file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	formatted, err := formatStdin(content, file)
+	if err != nil || bytes.Equal(formatted, content) {
+		return "", err
+	}
+	dst, err := mirrorPath(dir, root, file)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", err
+	}
+	return dst, os.WriteFile(dst, formatted, 0o644)
+}
+
+// normalizeLineEndings converts the line endings of the files that do not
+// already use the --normalize-line-endings style, backing each one up first,
+// and returns the files it changed. It does nothing without the flag.
+func normalizeLineEndings(root string, files []string) ([]string, error) {
+	if *normalizeEOL == "" {
+		return nil, nil
+	}
+	targets, err := lineEndingTargets(files)
+	if err != nil {
+		return nil, err
+	}
+	var normalized []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return normalized, err
+		}
+		converted := convertLineEndings(data, targets[file])
+		if bytes.Equal(converted, data) {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return normalized, err
+		}
+		if err := backupFiles(root, []string{file}); err != nil {
+			return normalized, err
+		}
+		if err := os.WriteFile(file, converted, info.Mode().Perm()); err != nil {
+			return normalized, err
+		}
+		normalized = append(normalized, file)
+	}
+	return normalized, nil
+}
+
+// lineEndingTargets returns the line ending, "lf" or "crlf", each file
+// should get. With auto a file's eol attribute decides, and files without
+// one get the style most tracked files use in the working tree.
+func lineEndingTargets(files []string) (map[string]string, error) {
+	targets := make(map[string]string, len(files))
+	if *normalizeEOL != "auto" {
+		for _, file := range files {
+			targets[file] = *normalizeEOL
+		}
+		return targets, nil
+	}
+
+	eol, err := checkAttr("eol", files)
+	if err != nil {
+		return nil, err
+	}
+	usual := usualLineEnding()
+	for _, file := range files {
+		switch eol[file] {
+		case "lf", "crlf":
+			targets[file] = eol[file]
+		default:
+			targets[file] = usual
+		}
+	}
+	return targets, nil
+}
+
+// usualLineEnding returns "crlf" if more tracked files use CRLF than LF in
+// the working tree, as git ls-files --eol reports it, and "lf" otherwise.
+func usualLineEnding() string {
+	out, err := exec.Command("git", "ls-files", "--eol").Output()
+	if err != nil {
+		return "lf"
+	}
+	// Each line starts "i/<index eol> w/<working tree eol> attr/...".
+	var lf, crlf int
+	for _, line := range strings.Split(string(out), "\n") {
+		for _, field := range strings.Fields(line) {
+			switch field {
+			case "w/lf":
+				lf++
+			case "w/crlf":
+				crlf++
+			}
+		}
+	}
+	if crlf > lf {
+		return "crlf"
+	}
+	return "lf"
+}
+
+// convertLineEndings returns data with every line ending changed to eol,
+// "lf" or "crlf".
+func convertLineEndings(data []byte, eol string) []byte {
+	lf := bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	if eol == "crlf" {
+		return bytes.ReplaceAll(lf, []byte("\n"), []byte("\r\n"))
+	}
+	return lf
+}
+
+// sameFile reports whether a and b name the same existing file or directory.
+func sameFile(a, b string) bool {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Stat(b)
+	return err == nil && os.SameFile(ia, ib)
+}
+
+// copyFile copies src to dst with the same permissions, creating dst's
+// parent directories.
+func copyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, info.Mode().Perm())
+}
+
+// hashFiles returns the SHA-256 of each readable file's content.
+func hashFiles(files []string) map[string][sha256.Size]byte {
+	hashes := make(map[string][sha256.Size]byte, len(files))
+	for _, file := range files {
+		if data, err := os.ReadFile(file); err == nil {
+			hashes[file] = sha256.Sum256(data)
+		}
+	}
+	return hashes
+}
+
+// without returns the files in all that are not in remove, keeping order.
+func without(all, remove []string) []string {
+	drop := make(map[string]bool, len(remove))
+	for _, file := range remove {
+		drop[file] = true
+	}
+	var kept []string
+	for _, file := range all {
+		if !drop[file] {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// changedSince returns the files whose content no longer matches before.
+func changedSince(files []string, before map[string][sha256.Size]byte) []string {
+	after := hashFiles(files)
+	var changed []string
+	for _, file := range files {
+		if after[file] != before[file] {
+			changed = append(changed, file)
+		}
+	}
+	return changed
+}
+
+// openReport returns the destination for the report: the file named by
+// --output-file, with its parent directories created, or stdout.
+func openReport() (io.WriteCloser, error) {
+	if *outputFile == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(*outputFile), 0o755); err != nil {
+		return nil, err
+	}
+	return os.Create(*outputFile)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
 
-	if flag.Arg(0) == "help" {
-		printHelp()
-		return
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+const sarifRuleID = "prettier/unformatted"
+
+// writeSARIF writes one SARIF result per unformatted file. Paths are made
+// relative to the repository root (or the current directory outside a
+// repository) so code scanning can map them onto the checkout.
+func writeSARIF(w io.Writer, unformatted []string) error {
+	base, err := reportBase()
+	if err != nil {
+		return err
 	}
 
-	if *allFiles {
-		if !confirmAction("This will format all files recursively in the current directory. Do you want to continue? (yes/no): ") {
-			fmt.Println("Operation canceled.")
-			return
+	results := []sarifResult{}
+	for _, file := range unformatted {
+		uri := reportPath(base, file)
+		results = append(results, sarifResult{
+			RuleID:  sarifRuleID,
+			Level:   "warning",
+			Message: sarifMessage{Text: "File is not formatted with prettier"},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: uri, URIBaseID: "%SRCROOT%"},
+				},
+			}},
+		})
+	}
+
+	doc := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "pretti",
+				InformationURI: "https://github.com/karthikeyaspace/pretti",
+				Rules: []sarifRule{{
+					ID:               sarifRuleID,
+					ShortDescription: sarifMessage{Text: "File is not formatted with prettier"},
+				}},
+			}},
+			Results: results,
+		}},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// reportBase returns the directory report paths are relative to: the
+// repository root, or the current directory outside a repository.
+func reportBase() (string, error) {
+	if base, err := getGitRoot(); err == nil {
+		return base, nil
+	}
+	return os.Getwd()
+}
+
+// reportPath returns file relative to base in slash form, or file itself
+// when it cannot be made relative.
+func reportPath(base, file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		if rel, err := filepath.Rel(base, abs); err == nil {
+			return filepath.ToSlash(rel)
 		}
-		formatAllFiles()
-		return
 	}
+	return filepath.ToSlash(file)
+}
 
-	gitRoot, err := getGitRoot()
+// writeAnnotations writes a GitHub Actions error annotation for each
+// unformatted file, so they show up on the pull request's diff. Prettier does
+// not say which lines it would change, so each points at line 1.
+func writeAnnotations(w io.Writer, unformatted []string) error {
+	base, err := reportBase()
 	if err != nil {
-		log.Fatalf("Error finding Git repository: %v", err)
+		return err
 	}
-
-	var files []string
-	if *current {
-		files, err = getChangedFiles(gitRoot)
-		if err != nil {
-			log.Fatalf("Error getting changed files: %v", err)
+	for _, file := range unformatted {
+		if _, err := fmt.Fprintf(w, "::error file=%s,line=1::File is not formatted\n", escapeAnnotation(reportPath(base, file))); err != nil {
+			return err
 		}
-	} else {
-		fmt.Println("No valid option selected. Use --current or --all.")
-		return
 	}
+	return nil
+}
+
+// escapeAnnotation escapes the characters that are special in a workflow
+// command property value.
+func escapeAnnotation(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
 
-	extensions := strings.Split(*extList, ",")
-	if *extList == "" {
-		extensions = []string{".js", ".ts", ".json", ".tsx", ".jsx"}
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr"`
+	Failure   *junitFailure `xml:"failure"`
+	Error     *junitFailure `xml:"error"`
+}
+
+// junitFailure is a test case's failure or error element. The message is a
+// one-line reason and the text repeats it with the file's path.
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes a JUnit XML report with one test case per file. Files
+// that need formatting fail, and files prettier could not format error.
+func writeJUnit(w io.Writer, res Result) error {
+	base, err := reportBase()
+	if err != nil {
+		return err
 	}
 
-	filtered := filterFiles(files, extensions)
-	if len(filtered) == 0 {
-		fmt.Println("No files to format")
-		return
+	suite := junitTestSuite{Name: "pretti", Tests: len(res.Files)}
+	for _, file := range res.Files {
+		path := reportPath(base, file)
+		tc := junitTestCase{Name: path, ClassName: "pretti", File: path}
+		if message, ok := res.Errored[file]; ok {
+			tc.Error = &junitFailure{Message: message, Text: path + ": " + message}
+			suite.Errors++
+		} else if slices.Contains(res.NeedsFormatting, file) {
+			tc.Failure = &junitFailure{Message: "File is not formatted", Text: path + ": prettier would reformat this file"}
+			suite.Failures++
+		}
+		suite.TestCases = append(suite.TestCases, tc)
 	}
 
-	if err := runPrettier(filtered); err != nil {
-		log.Fatalf("Error formatting files: %v", err)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
 	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
 
-	fmt.Printf("Successfully formatted %d files:\n", len(filtered))
-	for _, file := range filtered {
-		fmt.Println(" ", file)
+// formatToStdout returns prettier's formatted version of file without
+// writing it. Prettier's stderr is discarded.
+func formatToStdout(file string) ([]byte, error) {
+	b := batch{files: []string{file}, parser: parserOverride(file)}
+	cmd := prettierCommand(context.Background(), prettierArgs("", b.paths())...)
+	cmd.Stderr = io.Discard
+	defer stats.recordBatch([]string{file}, time.Now())
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return nil, &prettierExitError{code: exitErr.ExitCode()}
 	}
+	return out, err
 }
 
-func getGitRoot() (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+// formatPipe formats stdin with --parser and writes the result to stdout for
+// --pipe, as it comes from prettier. Nothing on disk is read or written.
+func formatPipe() error {
+	var args []string
+	name := *parser
+	if name == "" && *stdinFilepath != "" {
+		// What a file of that name would get; "" leaves it to prettier.
+		name = parserOverride(*stdinFilepath)
+	}
+	if name != "" {
+		args = append(args, "--parser", name)
+	}
+	if *stdinFilepath != "" {
+		args = append(args, "--stdin-filepath", *stdinFilepath)
+	}
+	cmd := prettierCommand(context.Background(), prettierArgs("", args)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = terminalStdout
+	noParser := false
+	cmd.Stderr = io.MultiWriter(prettierStderr(), &lineWriter{fn: func(line string) {
+		noParser = noParser || strings.Contains(line, "No parser could be inferred")
+	}})
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if noParser && name == "" {
+				return fmt.Errorf("prettier cannot tell the language of %s from its name; pass --parser: %w", *stdinFilepath, &prettierExitError{code: exitErr.ExitCode()})
+			}
+			return &prettierExitError{code: exitErr.ExitCode()}
+		}
+		return fmt.Errorf("failed to run prettier: %w (make sure it's installed and in PATH)", err)
+	}
+	return nil
+}
+
+// formatStdin returns prettier's formatting of content, inferring the parser
+// from path the same way prettier would for a file on disk.
+func formatStdin(content []byte, path string) ([]byte, error) {
+	args := []string{"--stdin-filepath", path}
+	if parser := parserOverride(path); parser != "" {
+		args = append([]string{"--parser", parser}, args...)
+	}
+	cmd := prettierCommand(context.Background(), prettierArgs("", args)...)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stderr = prettierStderr()
+	defer stats.recordBatch([]string{path}, time.Now())
 	out, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("git rev-parse failed: %w", err)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return nil, &prettierExitError{code: exitErr.ExitCode()}
 	}
-	return strings.TrimSpace(string(out)), nil
+	return out, err
 }
 
-func getChangedFiles(gitRoot string) ([]string, error) {
-	cmd := exec.Command("git", "diff", "--name-only")
+// formatStagedBlobs formats the staged version of each file instead of the
+// working tree copy and, outside check mode, writes the result back to the
+// index. Unstaged edits in the working tree are left alone. It returns the
+// files whose staged content changed (or would change).
+func formatStagedBlobs(gitRoot string, files []string) ([]string, error) {
+	type blob struct {
+		rel, mode string
+		formatted []byte
+		changed   bool
+		err       error
+	}
+	blobs := make([]blob, len(files))
+	parallel(len(files), *jobs, func(i int) {
+		b := &blobs[i]
+		b.rel = relPath(gitRoot, files[i])
+
+		out, err := gitOutput(gitRoot, "ls-files", "-s", "--", b.rel)
+		fields := strings.Fields(string(out))
+		if err != nil || len(fields) < 2 {
+			b.err = fmt.Errorf("%s is not in the index", b.rel)
+			return
+		}
+		b.mode = fields[0]
+
+		staged, err := gitOutput(gitRoot, "show", ":"+b.rel)
+		if err != nil {
+			b.err = err
+			return
+		}
+		if b.formatted, err = formatStdin(staged, files[i]); err != nil {
+			b.err = err
+			return
+		}
+		b.changed = !bytes.Equal(staged, b.formatted)
+	})
+
+	var changed []string
+	for i, b := range blobs {
+		if b.err != nil {
+			return changed, b.err
+		}
+		if !b.changed {
+			continue
+		}
+		changed = append(changed, files[i])
+		if checkMode() {
+			continue
+		}
+
+		// The index is updated one file at a time; concurrent update-index
+		// calls would fight over index.lock.
+		cmd := exec.Command("git", "hash-object", "-w", "--stdin", "--path", b.rel)
+		cmd.Dir = gitRoot
+		cmd.Stdin = bytes.NewReader(b.formatted)
+		sha, err := cmd.Output()
+		if err != nil {
+			return changed, fmt.Errorf("git hash-object failed: %w", err)
+		}
+		cacheInfo := b.mode + "," + strings.TrimSpace(string(sha)) + "," + b.rel
+		if _, err := gitOutput(gitRoot, "update-index", "--cacheinfo", cacheInfo); err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// gitOutput runs a git command in dir and returns its stdout.
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
 	out, err := cmd.Output()
 	if err != nil {
-		return nil, fmt.Errorf("git diff failed: %w", err)
+		return nil, fmt.Errorf("git %s failed: %w", args[0], err)
 	}
+	return out, nil
+}
 
-	var files []string
-	for _, file := range strings.Split(strings.TrimSpace(string(out)), "\n") {
-		if file == "" {
-			continue
+// printDiffStat formats each file to stdout, compares the result with the
+// file on disk and prints how many lines would be added and removed.
+func printDiffStat(files []string) int {
+	type fileStat struct {
+		added, removed int
+		err            error
+	}
+	results := make([]fileStat, len(files))
+	parallel(len(files), *jobs, func(i int) {
+		original, err := os.ReadFile(files[i])
+		if err != nil {
+			results[i].err = err
+			return
+		}
+		formatted, err := formatToStdout(files[i])
+		if err != nil {
+			results[i].err = err
+			return
+		}
+		results[i].added, results[i].removed = diffStat(string(original), string(formatted))
+	})
+
+	var changed, added, removed, failed int
+	for i, r := range results {
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Printf("  %s: %v\n", files[i], r.err)
+		case r.added+r.removed > 0:
+			changed++
+			added += r.added
+			removed += r.removed
+			fmt.Printf("  %s | +%d -%d\n", files[i], r.added, r.removed)
 		}
-		files = append(files, filepath.Join(gitRoot, file))
 	}
-	return files, nil
+	fmt.Printf("%d of %d files would change, %d insertions(+), %d deletions(-)\n", changed, len(files), added, removed)
+
+	if failed > 0 {
+		fmt.Printf("%d files could not be formatted\n", failed)
+		return 2
+	}
+	if *dryRunCheck && changed > 0 {
+		return 1
+	}
+	return 0
 }
 
-func filterFiles(files, exts []string) []string {
-	var filtered []string
-	includeAll := false
+// fileDiffStats formats each of files to stdout and compares the result with
+// the file on disk, for --report-diff-stats. Files prettier fails on this
+// time are left out.
+func fileDiffStats(files []string) map[string]DiffStat {
+	var mu sync.Mutex
+	stats := make(map[string]DiffStat, len(files))
+	parallel(len(files), *jobs, func(i int) {
+		original, err := os.ReadFile(files[i])
+		if err != nil {
+			return
+		}
+		formatted, err := formatToStdout(files[i])
+		if err != nil {
+			return
+		}
+		var stat DiffStat
+		stat.Added, stat.Removed = diffStat(string(original), string(formatted))
+		mu.Lock()
+		defer mu.Unlock()
+		stats[files[i]] = stat
+	})
+	return stats
+}
 
-	for _, ext := range exts {
-		if ext == "" {
-			includeAll = true
-			break
+// diffStat returns the number of lines added and removed by a minimal
+// line diff turning a into b.
+func diffStat(a, b string) (added, removed int) {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	d := editDistance(x, y)
+	common := (len(x) + len(y) - d) / 2
+	return len(y) - common, len(x) - common
+}
+
+// editDistance returns the length of the shortest edit script (insertions
+// plus deletions) between a and b, using Myers' O((N+M)D) algorithm.
+func editDistance(a, b []string) int {
+	n, m := len(a), len(b)
+	limit := n + m
+	off := limit + 1
+	v := make([]int, 2*limit+3)
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return d
+			}
 		}
 	}
+	return limit
+}
 
-	for _, file := range files {
-		if _, err := os.Stat(file); os.IsNotExist(err) {
-			continue
+// myersTrace runs the same search as editDistance but keeps it: entry d
+// holds the furthest x reached on each diagonal k in [-d, d] after d edits,
+// indexed by k+d, and the last entry is where the shortest edit script ends.
+// That takes O(D²) memory, so only touchedLines, which walks the script back,
+// uses it.
+func myersTrace(a, b []string) [][]int {
+	n, m := len(a), len(b)
+	limit := n + m
+	off := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return append(trace, slices.Clone(v[off-d:off+d+1]))
+			}
+		}
+		trace = append(trace, slices.Clone(v[off-d:off+d+1]))
+	}
+	return trace
+}
+
+// touchedLines reports, for every line of a, whether the shortest edit
+// script turning a into b removes it or inserts lines directly after it, so
+// a rewritten line counts once. Insertions before the first line count
+// against the first line.
+func touchedLines(a, b []string) []bool {
+	touched := make([]bool, len(a))
+	mark := func(i int) {
+		if len(touched) > 0 {
+			touched[max(i, 0)] = true
+		}
+	}
+
+	trace := myersTrace(a, b)
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
 		}
+		if x == prevX {
+			mark(x - 1)
+		} else {
+			mark(prevX)
+		}
+		x, y = prevX, prevY
+	}
+	return touched
+}
+
+// lineRange is an inclusive range of 1-based line numbers.
+type lineRange struct {
+	start, end int
+}
+
+// changedRanges returns the line ranges of file that the selected diff
+// touches, read from the new-side hunk headers of git diff -U0. A hunk that
+// only deletes lines touches the lines on either side of the deletion.
+func changedRanges(file string, revs []string) ([]lineRange, error) {
+	args := append([]string{"diff", "-U0", "--no-color", "--no-ext-diff"}, revs...)
+	out, err := exec.Command("git", append(args, "--", file)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %w", err)
+	}
 
-		if includeAll {
-			filtered = append(filtered, file)
+	var ranges []lineRange
+	for _, line := range strings.Split(string(out), "\n") {
+		if !strings.HasPrefix(line, "@@ ") {
+			continue
+		}
+		// @@ -a,b +c,d @@
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
 			continue
 		}
+		startText, countText, hasCount := strings.Cut(fields[2][1:], ",")
+		start, err := strconv.Atoi(startText)
+		if err != nil {
+			return nil, fmt.Errorf("bad hunk header %q", line)
+		}
+		count := 1
+		if hasCount {
+			if count, err = strconv.Atoi(countText); err != nil {
+				return nil, fmt.Errorf("bad hunk header %q", line)
+			}
+		}
+		if count == 0 {
+			ranges = append(ranges, lineRange{max(start, 1), start + 1})
+		} else {
+			ranges = append(ranges, lineRange{start, start + count - 1})
+		}
+	}
+	return ranges, nil
+}
+
+// checkChangedLines returns the files where prettier would change at least
+// one line that the selected diff touches.
+func checkChangedLines(root string, files []string) ([]string, error) {
+	needs := make([]bool, len(files))
+	errs := make([]error, len(files))
+	parallel(len(files), *jobs, func(i int) {
+		needs[i], errs[i] = changedLinesNeedFormatting(files[i])
+	})
+
+	var unformatted []string
+	for i, file := range files {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("%s: %w", relPath(root, file), errs[i])
+		}
+		if needs[i] {
+			unformatted = append(unformatted, file)
+		}
+	}
+	return unformatted, nil
+}
 
-		for _, ext := range exts {
-			if strings.HasSuffix(file, ext) {
-				filtered = append(filtered, file)
-				break
+func changedLinesNeedFormatting(file string) (bool, error) {
+	ranges, err := changedRanges(file, diffRevs)
+	if err != nil || len(ranges) == 0 {
+		return false, err
+	}
+	original, err := os.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	formatted, err := formatToStdout(file)
+	if err != nil {
+		return false, err
+	}
+
+	touched := touchedLines(strings.Split(string(original), "\n"), strings.Split(string(formatted), "\n"))
+	for _, r := range ranges {
+		for line := r.start; line <= r.end && line <= len(touched); line++ {
+			if touched[line-1] {
+				return true, nil
 			}
 		}
 	}
-	return filtered
+	return false, nil
 }
 
-func runPrettier(files []string) error {
-	args := append([]string{"--write"}, files...)
-	cmd := exec.Command("prettier", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// parallel calls fn for every index in [0, n) using at most workers
+// goroutines and returns when all calls have finished.
+func parallel(n, workers int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
 
-	if err := cmd.Run(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return fmt.Errorf("prettier exited with code %d", exitErr.ExitCode())
+// runStats collects timing for each phase of a run for --stats-json.
+type runStats struct {
+	mu        sync.Mutex
+	Selection time.Duration
+	Filtering time.Duration
+	Batches   []batchStats
+}
+
+type batchStats struct {
+	Files    int
+	Duration time.Duration
+}
+
+var stats runStats
+
+// recordBatch records one prettier invocation over files that started at
+// start. It is shaped to be deferred with time.Now() as the argument. With
+// --verbose the timing is also printed, and batches slower than
+// --slow-threshold are flagged along with their files.
+func (s *runStats) recordBatch(files []string, start time.Time) {
+	d := time.Since(start)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Batches = append(s.Batches, batchStats{Files: len(files), Duration: d})
+
+	if !*verbose {
+		return
+	}
+	if *slowThreshold <= 0 || d <= *slowThreshold {
+		fmt.Fprintf(os.Stderr, "prettier: %d files in %s\n", len(files), d.Round(time.Millisecond))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "prettier: %d files in %s (slower than %s):\n", len(files), d.Round(time.Millisecond), *slowThreshold)
+	for _, file := range files {
+		fmt.Fprintln(os.Stderr, " ", file)
+	}
+}
+
+func writeStats(w io.Writer) error {
+	type batch struct {
+		Files      int     `json:"files"`
+		DurationMs float64 `json:"durationMs"`
+	}
+	doc := struct {
+		SelectionMs  float64 `json:"selectionMs"`
+		FilteringMs  float64 `json:"filteringMs"`
+		FormattingMs float64 `json:"formattingMs"`
+		Batches      []batch `json:"batches"`
+	}{
+		SelectionMs: millis(stats.Selection),
+		FilteringMs: millis(stats.Filtering),
+		Batches:     []batch{},
+	}
+	var formatting time.Duration
+	for _, b := range stats.Batches {
+		formatting += b.Duration
+		doc.Batches = append(doc.Batches, batch{Files: b.Files, DurationMs: millis(b.Duration)})
+	}
+	doc.FormattingMs = millis(formatting)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// allCommitted reports whether every file is tracked by git and has no
+// staged or unstaged changes, so git checkout restores what formatting
+// changes. It is false when git cannot tell.
+func allCommitted(files []string) bool {
+	gitRoot, err := getGitRoot()
+	if err != nil {
+		return false
+	}
+	changed, err := dirtyFiles(gitRoot, true)
+	if err != nil {
+		return false
+	}
+	dirty := make(map[string]bool)
+	for _, file := range changed {
+		dirty[filepath.Join(gitRoot, file)] = true
+	}
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil || dirty[abs] {
+			return false
 		}
-		return fmt.Errorf("failed to run prettier: %w (make sure it's installed and in PATH)", err)
 	}
-	return nil
+	return true
 }
 
-func formatAllFiles() {
-	cmd := exec.Command("prettier", "--write", "./**/*")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// dirtyFiles returns the files git status lists in gitRoot, relative to it:
+// those with staged or unstaged changes, untracked files, and with ignored set
+// the untracked ignored ones too. A rename or copy lists both of its paths.
+func dirtyFiles(gitRoot string, ignored bool) ([]string, error) {
+	args := []string{"status", "--porcelain", "-z", "--untracked-files=all"}
+	if ignored {
+		args = append(args, "--ignored")
+	}
+	out, err := gitOutput(gitRoot, args...)
+	if err != nil {
+		return nil, err
+	}
+	// With -z the entries are "XY path"; renames and copies add the
+	// source path as the next entry.
+	var files []string
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		files = append(files, entry[3:])
+		if (entry[0] == 'R' || entry[0] == 'C') && i+1 < len(entries) {
+			i++
+			files = append(files, entries[i])
+		}
+	}
+	return files, nil
+}
 
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("Error formatting all files: %v", err)
+// checkCleanWorktree returns an error listing the files, other than files,
+// that git status shows changes to, for --require-clean-worktree. Ignored
+// files do not count.
+func checkCleanWorktree(files []string) error {
+	gitRoot, err := getGitRoot()
+	if err != nil {
+		return fmt.Errorf("--require-clean-worktree needs a git repository: %w", err)
+	}
+	dirty, err := dirtyFiles(gitRoot, false)
+	if err != nil {
+		return err
 	}
-	fmt.Println("Successfully formatted all files.")
+	selected := make(map[string]bool, len(files))
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			selected[abs] = true
+		}
+	}
+	var unexpected []string
+	for _, file := range dirty {
+		if !selected[filepath.Join(gitRoot, file)] {
+			unexpected = append(unexpected, file)
+		}
+	}
+	if len(unexpected) == 0 {
+		return nil
+	}
+	slices.Sort(unexpected)
+	unexpected = slices.Compact(unexpected)
+	return fmt.Errorf("%d files outside the selection have uncommitted changes (--require-clean-worktree):\n  %s", len(unexpected), strings.Join(unexpected, "\n  "))
 }
 
 func confirmAction(prompt string) bool {
@@ -162,11 +6885,441 @@ func confirmAction(prompt string) bool {
 	return response == "yes"
 }
 
+// subcommands are the positional commands pretti understands, used to build
+// shell completion scripts.
+var subcommands = []struct {
+	name, usage string
+}{
+	{"changed", "Format files with unstaged changes"},
+	{"staged", "Format files staged for commit"},
+	{"all", "Format all files below the current directory"},
+	{"list", "Print the files that would be formatted"},
+	{"completion", "Print a shell completion script"},
+	{"update-check", "Check whether a newer release is available"},
+	{"doctor", "Check that git, config, the runner and prettier resolve"},
+	{"validate-config", "Check the config files and PRETTI_* variables for mistakes"},
+	{"help", "Show the help message"},
+}
+
+// writeCompletion writes a completion script for shell, generated from the
+// registered flags and subcommands.
+func writeCompletion(w io.Writer, shell string) error {
+	type flagInfo struct {
+		name, usage string
+		isBool      bool
+	}
+	var flags []flagInfo
+	flag.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, flagInfo{f.Name, f.Usage, ok && b.IsBoolFlag()})
+	})
+
+	var b strings.Builder
+	switch shell {
+	case "bash":
+		var names, cmds []string
+		for _, f := range flags {
+			names = append(names, "--"+f.name)
+		}
+		for _, c := range subcommands {
+			cmds = append(cmds, c.name)
+		}
+		fmt.Fprintf(&b, `_pretti() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    if [ "$COMP_CWORD" -eq 1 ] && [[ "$cur" != -* ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        return
+    fi
+    if [ "${COMP_WORDS[1]}" = completion ]; then
+        COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+        return
+    fi
+    COMPREPLY=($(compgen -W "%s" -- "$cur"))
+}
+complete -o default -F _pretti pretti
+`, strings.Join(cmds, " "), strings.Join(names, " "))
+	case "zsh":
+		esc := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+		b.WriteString("#compdef pretti\n\n_pretti() {\n    local -a commands\n    commands=(\n")
+		for _, c := range subcommands {
+			fmt.Fprintf(&b, "        '%s:%s'\n", c.name, esc.Replace(c.usage))
+		}
+		b.WriteString("    )\n    _arguments \\\n")
+		for _, f := range flags {
+			if f.isBool {
+				fmt.Fprintf(&b, "        '--%s[%s]' \\\n", f.name, esc.Replace(f.usage))
+			} else {
+				fmt.Fprintf(&b, "        '--%s=[%s]:%s:_files' \\\n", f.name, esc.Replace(f.usage), f.name)
+			}
+		}
+		b.WriteString("        '1: :_describe command commands' \\\n        '*:file:_files'\n}\n\n_pretti \"$@\"\n")
+	case "fish":
+		esc := strings.NewReplacer("\\", "\\\\", "'", "\\'")
+		for _, c := range subcommands {
+			fmt.Fprintf(&b, "complete -c pretti -n __fish_use_subcommand -f -a %s -d '%s'\n", c.name, esc.Replace(c.usage))
+		}
+		b.WriteString("complete -c pretti -n '__fish_seen_subcommand_from completion' -f -a 'bash zsh fish'\n")
+		for _, f := range flags {
+			req := ""
+			if !f.isBool {
+				req = " -r"
+			}
+			fmt.Fprintf(&b, "complete -c pretti -l %s%s -d '%s'\n", f.name, req, esc.Replace(f.usage))
+		}
+	default:
+		return fmt.Errorf("usage: pretti completion <bash|zsh|fish>")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
 func printHelp() {
-	fmt.Println("Usage: pretti [options]")
+	fmt.Println("Usage: pretti [command] [options] [file...]")
+	fmt.Println()
+	fmt.Println("Files named on the command line are formatted directly, without asking git, and")
+	fmt.Println("take precedence over the changed, staged and all commands and every selection option.")
+	fmt.Println("Arguments with glob characters, such as \"src/**/*.ts\", are expanded by pretti itself,")
+	fmt.Println("so they work the same in shells that do not expand them; ** matches any directories.")
+	fmt.Println()
+	fmt.Println("By default pretti rewrites the selected files in place (prettier --write).")
+	fmt.Println("Use --check or --no-write to only report files that need formatting.")
+	fmt.Println()
+	fmt.Println("Configuration:")
+	fmt.Println("  A .prettirc JSON file at the repository root is read on every run, e.g.")
+	fmt.Println("    {\"exclude\": [\"*.snap\", \"fixtures\"]}")
+	fmt.Println("  Its exclude patterns are added to the defaults and to --exclude. An include_dirs")
+	fmt.Println("  array, e.g. [\"src\", \"packages/web\"], limits every run to files under those")
+	fmt.Println("  directories; other files are skipped as out-of-scope. Any other key sets the")
+	fmt.Println("  default for the flag of that name, e.g. {\"jobs\": 4, \"ext\": \".ts,.tsx\"}. An ext_aliases")
+	fmt.Println("  object names extension groups, e.g. {\"web\": [\".ts\", \".tsx\", \".css\"]}, that --ext")
+	fmt.Println("  expands: --ext @web,.md. An alias may use other aliases, and .prettirc overrides")
+	fmt.Println("  the global config's alias of the same name. A parsers object, e.g. {\".jsonc\": \"json\"},")
+	fmt.Println("  passes --parser for files with those extensions, which are then selected too unless")
+	fmt.Println("  --ext or --lang is given; prettier reports a parser it does not know as an error.")
+	fmt.Println("  Machine-wide defaults go in $XDG_CONFIG_HOME/pretti/config.json (~/.config by")
+	fmt.Println("  default) in the same format, and PRETTI_<FLAG> environment variables, e.g.")
+	fmt.Println("  PRETTI_JOBS=4, set flags too. Precedence: command line, environment, .prettirc,")
+	fmt.Println("  global config, built-in defaults.")
+	fmt.Println("  --all also skips what git ignores: the .gitignore files of the repository root")
+	fmt.Println("  and of every directory down to the files, read the same way as .prettiignore below,")
+	fmt.Println("  and .git/info/exclude, plus the .prettierignore in the directory it runs from;")
+	fmt.Println("  --no-ignore turns this off.")
+	fmt.Println("  A .prettiignore file at the repository root lists more paths to skip, read the way")
+	fmt.Println("  git reads .gitignore: the last matching pattern wins, so !keep.ts re-includes a file")
+	fmt.Println("  an earlier pattern ignored (but not one inside an ignored directory), /dist only")
+	fmt.Println("  matches at the root and build/ only matches directories. It applies in every mode,")
+	fmt.Println("  after --exclude, .prettirc excludes and the ignore files above, and --no-ignore does")
+	fmt.Println("  not turn it off. Prettier does not read it, so running prettier directly is unaffected.")
+	fmt.Println()
+	fmt.Println("Exit codes:")
+	fmt.Println("  0                                 Success; in check mode, every file is formatted or there was nothing to check, whether")
+	fmt.Println("                                    nothing changed or the filters dropped every file (--empty-exit-code changes this)")
+	fmt.Println("  1                                 Check mode or --format-then-check found files that need formatting, or pretti itself could not run")
+	fmt.Println("  2                                 Prettier failed, e.g. a file could not be parsed (prettier's own exit code is passed through)")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  changed                           Format files with unstaged changes (same as --current)")
+	fmt.Println("  staged                            Format files staged for commit (same as --staged)")
+	fmt.Println("  all                               Format all files below the current directory (same as --all)")
+	fmt.Println("  list                              Print the files that would be formatted, one per line, without running prettier")
+	fmt.Println("  completion <shell>                Print a completion script for bash, zsh or fish")
+	fmt.Println("  update-check                      Check GitHub for a newer pretti release (only when asked; nothing is downloaded)")
+	fmt.Println("  doctor                            Check the environment before a run: git and the repository, the config files,")
+	fmt.Println("                                    --runner and prettier's version; prints ok or FAIL per check, exits 1 on a failure")
+	fmt.Println("  validate-config                   Check the global config, .prettirc and PRETTI_* environment variables without")
+	fmt.Println("                                    running: reports every unknown key and invalid value with its file and key, and")
+	fmt.Println("                                    exits 1 if there are any. --report-config-source shows what the values resolve to")
+	fmt.Println("  help                              Show this help message")
+	fmt.Println("  Options may come before or after the command. With no command, files or selection")
+	fmt.Println("  option, pretti prints this help.")
+	fmt.Println()
 	fmt.Println("Options:")
-	fmt.Println("  --ext <exts>     Comma-separated file extensions to include (default: .js, .ts, .json, .tsx, .jsx)")
-	fmt.Println("  --all            Format all files recursively in the current directory (asks for confirmation)")
-	fmt.Println("  --current        Format only changed files in the current branch")
-	fmt.Println("  help             Show this help message")
+	fmt.Println("  --ext <exts>                      Comma-separated file extensions to include (default: .js, .ts, .json, .tsx, .jsx);")
+	fmt.Println("                                    @name uses the extensions of an ext_aliases entry in .prettirc.")
+	fmt.Println("                                    The default does not apply to named files (see --strict-ext)")
+	fmt.Println("  --lang <langs>                    Comma-separated languages to include, combined with --ext")
+	fmt.Println("                                    (javascript, typescript, json, css, markdown, yaml)")
+	fmt.Println("  --auto-ext                        Default to every extension the installed prettier supports (prettier --support-info)")
+	fmt.Println("  --auto-select-ext                 Format the selected files whose extension the installed prettier supports, whatever")
+	fmt.Println("                                    the extension; --verbose prints the extensions found. With --all it is --auto-ext")
+	fmt.Println("  --strict-ext                      Fail when an --ext extension is not one prettier supports (checked with --support-info),")
+	fmt.Println("                                    and filter named files by extension too. Files named on the command line, with")
+	fmt.Println("                                    --from-file or with --manifest are otherwise formatted whatever their extension")
+	fmt.Println("                                    unless --ext or --lang is given; git selections and --all use the default")
+	fmt.Println("                                    extensions")
+	fmt.Println("  --ext-from-gitattributes          Also format files whose linguist-language attribute (git check-attr) names a")
+	fmt.Println("                                    language prettier formats, e.g. *.conf linguist-language=JSON, using that language's parser")
+	fmt.Println("  --force-parser <name>             Format every selected file with prettier's <name> parser, e.g. json or yaml, instead")
+	fmt.Println("                                    of the one its extension implies, e.g. for config files with odd names. It applies")
+	fmt.Println("                                    to the whole selection, so pair it with a narrow --ext such as --ext .conf")
+	fmt.Println("  --exclude <globs>                 Comma-separated glob patterns of paths to skip, added to the default excludes")
+	fmt.Println("  --resolve-symlinks                Format what symlinks point to instead of skipping them: --all follows symlinked")
+	fmt.Println("                                    directories, and every file is formatted once under its resolved path. Targets")
+	fmt.Println("                                    outside the repository are skipped and reported as outside-repository")
+	fmt.Println("  --no-default-excludes             Do not skip node_modules, dist, build, coverage, .next and vendor")
+	fmt.Println("  --respect-gitignore               Skip named files, including --from-file ones, that .gitignore ignores (git check-ignore);")
+	fmt.Println("                                    they are reported as ignored by --report-skipped-reasons")
+	fmt.Println("  --no-ignore                       Format files listed in .gitignore or .prettierignore too; by default --all skips them")
+	fmt.Println("                                    and prettier skips them in every mode")
+	fmt.Println("  --modified-after <duration|date>  Skip files last modified before this, e.g. 720h (30 days ago), 2024-01-31 or")
+	fmt.Println("                                    an RFC 3339 timestamp")
+	fmt.Println("  --format-empty-files              Format zero-length files too; by default they are skipped (reported as empty),")
+	fmt.Println("                                    since prettier has nothing to do for them and some plugins fail on them.")
+	fmt.Println("                                    Same as --skip-empty-files=false")
+	fmt.Println("  --codeowners <owner>              Only format files CODEOWNERS (.github/, the root or docs/) assigns to <owner>, a")
+	fmt.Println("                                    @user, @org/team or email; the last matching line decides, as on GitHub. The")
+	fmt.Println("                                    other files are skipped as not-owned")
+	fmt.Println("  --content-match <regex>           Only format files whose content matches <regex> (Go syntax), e.g. an opt-in")
+	fmt.Println("                                    pragma: --content-match '@format'. Binary files are skipped. Every candidate")
+	fmt.Println("                                    left after the other filters is read, so this is slower on large selections")
+	fmt.Println("  --all                             Same as the all command, kept for compatibility: format all files recursively in the")
+	fmt.Println("                                    current directory (asks for confirmation)")
+	fmt.Println("  --yes                             Do not ask for confirmation before formatting with --all")
+	fmt.Println("  --warn-on-large-selection <n>     Print a warning before formatting when more than n files are selected, then go on;")
+	fmt.Println("                                    a softer guard than --confirm-threshold")
+	fmt.Println("  --confirm-threshold <n>           With --all, only ask for confirmation when more than n files would be formatted")
+	fmt.Println("  --no-confirm-if-clean             With --all, do not ask when every selected file is tracked and has no uncommitted")
+	fmt.Println("                                    changes, since git checkout undoes the run")
+	fmt.Println("  --max-depth <n>                   With --all, do not descend more than n directories below the root")
+	fmt.Println("  --root <dir>                      Run as if started in dir, the repository root: git runs there, relative paths and")
+	fmt.Println("                                    --all resolve against it, and its .prettirc is read. dir must contain .git")
+	fmt.Println("  --no-git                          With --root, accept a dir without .git; only --all and named files work there")
+	fmt.Println("  --include-nested-repos            With --all, also format files inside nested git repositories, which are skipped")
+	fmt.Println("                                    by default")
+	fmt.Println("  --jobs <n>                        Number of parallel workers (default: number of CPUs)")
+	fmt.Println("  --walk-concurrency <n>            Number of top-level directories --all walks and filters at a time, separately from the")
+	fmt.Println("                                    prettier processes --jobs runs; walking waits on the disk rather than the CPU, so it")
+	fmt.Println("                                    defaults to twice the number of CPUs")
+	fmt.Println("  --max-open-files <n>              Most files the conflict-marker scan and --content-match read at the same time")
+	fmt.Println("                                    (default: 64), however many directories --walk-concurrency walks; lower it if a")
+	fmt.Println("                                    large selection fails with \"too many open files\" under a low ulimit -n")
+	fmt.Println("  --schedule <order>                Order files are handed to prettier in: selection (the default) or largest-first,")
+	fmt.Println("                                    which spreads the biggest files over the --jobs workers and starts them first so")
+	fmt.Println("                                    they do not straggle at the end; helps when a few files are much larger than the rest")
+	fmt.Println("  --progress <unit>                 Print progress to stderr as prettier batches finish, redrawn in place on a terminal:")
+	fmt.Println("                                    files counts finished files, bytes their size against the total size selected, a")
+	fmt.Println("                                    more honest estimate when a few huge files dominate. bytes also defaults --schedule")
+	fmt.Println("                                    to largest-first")
+	fmt.Println("  --batch-size <n>                  Pass at most n files to each prettier invocation, for benchmarking; by default the")
+	fmt.Println("                                    files are split evenly over --jobs in batches of up to 200. A very large n can")
+	fmt.Println("                                    exceed the system's command-line length limit")
+	fmt.Println("  --max-memory-mb <n>               Run fewer than --jobs prettier processes at once if they would need more than")
+	fmt.Println("                                    n MB, counting about 150 MB each plus pretti's own memory; on Linux, also stay")
+	fmt.Println("                                    within the memory /proc/meminfo reports as available. A crude guard against OOM kills")
+	fmt.Println("  --fail-fast                       Stop at the first failing prettier batch; by default the remaining batches still run")
+	fmt.Println("  --per-file-timeout <duration>     Run prettier separately for each file and kill a run that takes longer, e.g. 30s; the")
+	fmt.Println("                                    file is reported as an error and the other files still run. Slower for many small files")
+	fmt.Println("  --time-budget <duration>          Stop starting prettier batches once this long has passed, e.g. 10m, and report how")
+	fmt.Println("                                    many files were left out; batches already running finish. Exits 0 all the same")
+	fmt.Println("  --resume-journal                  Make every write run record its finished batches in .git/pretti-resume, removed")
+	fmt.Println("                                    again when the run completes. Off by default; set \"resume-journal\": true in")
+	fmt.Println("                                    .prettirc to keep it on for long runs that may be interrupted")
+	fmt.Println("  --resume                          Skip the files an interrupted --resume-journal run already formatted: rerunning the")
+	fmt.Println("                                    same command with --resume after a Ctrl-C, a prettier failure or --time-budget")
+	fmt.Println("                                    running out picks up where it stopped; other arguments start over. Keeps the")
+	fmt.Println("                                    journal going, so it also implies --resume-journal")
+	fmt.Println("  --max-errors <n>                  Stop the remaining work once prettier has reported errors for n files (default: no limit)")
+	fmt.Println("  --current                         Same as the changed command, kept for compatibility: format files with unstaged")
+	fmt.Println("                                    changes; git diff, the working tree against the index. --changed-files-source worktree")
+	fmt.Println("  --changed-dirs                    Format every file directly in a directory that has a changed file, not only the")
+	fmt.Println("                                    changed files, so related files stay consistent; subdirectories are not entered.")
+	fmt.Println("                                    Works with --staged, --base and the other git selections, and on its own with")
+	fmt.Println("                                    --current's unstaged changes; the --ext and ignore filters still apply")
+	fmt.Println("  --staged                          Same as the staged command: format files staged for commit; git diff --cached,")
+	fmt.Println("                                    the index against HEAD. --changed-files-source staged")
+	fmt.Println("  --changed-files-source <sources>  Choose what counts as changed: worktree (unstaged changes), staged and untracked")
+	fmt.Println("                                    (new files .gitignore does not exclude), combined with + or commas, e.g.")
+	fmt.Println("                                    worktree+staged for every change since HEAD or worktree+untracked. Replaces")
+	fmt.Println("                                    --current and --staged, which stay as the worktree and staged shorthands")
+	fmt.Println("  --hook                            Pre-commit preset, exactly --staged --quiet --quiet-prettier: silent when formatting")
+	fmt.Println("                                    succeeds, full prettier output and summary when it fails")
+	fmt.Println("  --base <ref>                      Format files changed since the merge base of <ref> and HEAD, including uncommitted")
+	fmt.Println("                                    changes: git diff <merge-base>, the working tree against the merge base")
+	fmt.Println("  --head <rev>                      With --base and --check, check the files changed between the merge base and <rev>,")
+	fmt.Println("                                    read with git show from <rev>, so nothing needs to be checked out (e.g. in a bare or")
+	fmt.Println("                                    shallow CI clone that has both commits). Writing is refused, since there is no file to write")
+	fmt.Println("  --ci-base                         Take --base from the CI environment when it is not given: GITHUB_BASE_REF on")
+	fmt.Println("                                    GitHub Actions, CI_MERGE_REQUEST_DIFF_BASE_SHA or _TARGET_BRANCH_NAME on GitLab")
+	fmt.Println("  --first-parent                    With --base, --since-tag or --since-last-run, select only the files changed by")
+	fmt.Println("                                    commits on the first-parent line (git log --first-parent --no-merges), plus")
+	fmt.Println("                                    uncommitted changes for --base and --since-last-run. This only changes the")
+	fmt.Println("                                    selection when the range has merges: files that only a merged side branch")
+	fmt.Println("                                    touched are left out, even though git diff would list them")
+	fmt.Println("  --merge <sha>                     Format the files merge commit <sha> changed beyond its parents, e.g. while resolving")
+	fmt.Println("                                    conflicts: its combined diff, git diff-tree --cc <sha>. Files the merge took")
+	fmt.Println("                                    unchanged from one side are left out; for everything the merge brought in,")
+	fmt.Println("                                    use --base <sha>^1 instead")
+	fmt.Println("  --stash-files <ref>               Format the files the stash <ref> touched, e.g. stash@{0}: its working tree and")
+	fmt.Println("                                    index changes against the commit it was made on, plus its untracked files with")
+	fmt.Println("                                    stash -u. The files are formatted as they are in the working tree, which assumes")
+	fmt.Println("                                    the stash was popped or applied since; git stash pop drops the ref, so name it by")
+	fmt.Println("                                    its commit (git stash pop prints it) or use apply, then git stash drop")
+	fmt.Println("  --since-tag <tag>                 Format files changed in commits since <tag>: git diff <tag>..HEAD, so uncommitted")
+	fmt.Println("                                    changes are left out; @latest uses the most recent tag")
+	fmt.Println("  --since-last-run                  Format files changed since the last successful run: git diff against the HEAD it")
+	fmt.Println("                                    recorded in .git/pretti-state, so uncommitted changes are included. Every run that")
+	fmt.Println("                                    writes files successfully records its HEAD; with no record yet, formats the files")
+	fmt.Println("                                    changed since HEAD")
+	fmt.Println("  --from-file <file>                Format the files listed in <file>, one per line, as if they were named on the command")
+	fmt.Println("                                    line; - reads the list from stdin. A --report-format json report works as the list,")
+	fmt.Println("                                    taking its needsFormatting files, which closes a check-then-fix loop:")
+	fmt.Println("                                    pretti --check --json > r.json; pretti --from-file r.json (or from jq:")
+	fmt.Println("                                    jq -r '.needsFormatting[]' r.json | pretti --from-file -). Run both from the same")
+	fmt.Println("                                    directory, since the report's paths are as pretti printed them")
+	fmt.Println("  --manifest <file>                 Format the files a JSON manifest lists, as if they were named on the command line:")
+	fmt.Println("                                    {\"files\": [{\"path\": \"a.ts\"}, {\"path\": \"weird\", \"parser\": \"json\"}]}. A \"parser\" is")
+	fmt.Println("                                    passed to prettier as --parser for that file, which also lets it past --ext; - reads")
+	fmt.Println("                                    the manifest from stdin")
+	fmt.Println("  --pipe --parser <name>            Format stdin with prettier's <name> parser, e.g. typescript or json, and write the")
+	fmt.Println("                                    result to stdout, e.g. for generated code that is never on disk")
+	fmt.Println("  --pipe --stdin-filepath <path>    Format stdin as the content of <path>, e.g. from an editor: prettier infers the")
+	fmt.Println("                                    parser from its name (or a .prettirc parsers entry does) and applies the config")
+	fmt.Println("                                    and ignore files that cover it. --parser overrides the inferred parser and is")
+	fmt.Println("                                    needed when the name has no extension prettier knows")
+	fmt.Println("  --patch <file>                    Format the existing files a patch touches (its +++ headers), relative to the git root;")
+	fmt.Println("                                    files the patch deletes are skipped")
+	fmt.Println("  --from-hook <hook>                Select files as a git hook sees them: pre-commit formats the staged files, pre-push")
+	fmt.Println("                                    reads the pushed refs from stdin and formats the files changed by the commits being pushed")
+	fmt.Println("  --strict                          With --staged, refuse to run when a staged file also has unstaged changes; in")
+	fmt.Println("                                    any mode, also --abort-on-conflict-markers")
+	fmt.Println("  --abort-on-conflict-markers       Stop when a selected file still has merge conflict markers (<<<<<<< and")
+	fmt.Println("                                    >>>>>>> lines). Such files are always left out, since formatting them corrupts the")
+	fmt.Println("                                    conflict; by default pretti lists them on stderr and formats the rest")
+	fmt.Println("  --require-clean-worktree          Refuse to write anything when git status shows changes, including untracked files,")
+	fmt.Println("                                    to files outside the selection, and list them; the selected files may be dirty.")
+	fmt.Println("                                    Keeps an automated fix from mixing with unrelated edits")
+	fmt.Println("  --only-staged-hunks               Format the staged content of each staged file and restage it, leaving")
+	fmt.Println("                                    unstaged edits in the working tree untouched (implies --staged)")
+	fmt.Println("  --check                           Report files that need formatting without writing them; exits 1 if any")
+	fmt.Println("  --no-write                        Alias for --check")
+	fmt.Println("  --check-only-changed-lines        With --check, only report files where prettier would change lines touched")
+	fmt.Println("                                    by the selected diff, ignoring existing formatting debt elsewhere in the file.")
+	fmt.Println("                                    With --staged, every staged file must be free of unstaged changes")
+	fmt.Println("  --format-modified-only            Check first and only write the files prettier would change; reports how many")
+	fmt.Println("                                    were already formatted")
+	fmt.Println("  --format-then-check               After writing, check the files again; exits 1 and lists them if prettier did")
+	fmt.Println("                                    not converge in one pass")
+	fmt.Println("  --normalize-line-endings <style>  Before formatting, convert files whose line endings are not <style>: lf, crlf or auto")
+	fmt.Println("                                    (the file's .gitattributes eol, else the style most tracked files use). The converted")
+	fmt.Println("                                    files are counted separately; set prettier's endOfLine to match, e.g. --opt endOfLine=auto")
+	fmt.Println("  --preview-dir <dir>               Write prettier's output for each file into <dir>, mirrored like --backup, and leave")
+	fmt.Println("                                    the files alone; diff the two trees to review a large change before applying it")
+	fmt.Println("  --diff-tool <cmd>                 Open each file prettier would change in <cmd>, run as <cmd> <file> <formatted copy>")
+	fmt.Println("                                    one file at a time, and write nothing; the copies live in a temporary directory")
+	fmt.Println("                                    that is removed afterwards. git uses git difftool, so the tool git config")
+	fmt.Println("                                    diff.tool names opens, e.g. git config diff.tool vimdiff")
+	fmt.Println("  --backup <dir>                    Copy each file into <dir>, at its path relative to the root, before prettier writes it.")
+	fmt.Println("                                    Git already keeps tracked files; this covers untracked files and runs outside a repository")
+	fmt.Println("  --report-format <format>          How to print the result: text (default), json, sarif, github or junit")
+	fmt.Println("                                    json: {\"mode\", \"files\", \"formatted\", \"unchanged\", \"needsFormatting\"};")
+	fmt.Println("                                    in write mode \"formatted\" lists only files whose content changed. These")
+	fmt.Println("                                    names are stable; --from-file reads \"needsFormatting\" back")
+	fmt.Println("                                    sarif: a SARIF v2.1.0 document of the unformatted files (requires --check)")
+	fmt.Println("                                    github: the text summary after one ::error annotation per unformatted file;")
+	fmt.Println("                                    the default for --check when GITHUB_ACTIONS=true")
+	fmt.Println("                                    junit: a JUnit XML report with one test case per file, failed when it needs")
+	fmt.Println("                                    formatting and errored when prettier failed on it; written even when prettier fails")
+	fmt.Println("  --json, --sarif                   Same as --report-format json and --report-format sarif")
+	fmt.Println("  --emit-events <file|fd:N>         Write lifecycle events as JSON lines to <file>, or to the open file descriptor N:")
+	fmt.Println("                                    run-start {mode, files, jobs}, file-start and file-done {path, batch, status,")
+	fmt.Println("                                    message, durationMs} for each file as its prettier batch starts and finishes,")
+	fmt.Println("                                    and run-end {exitCode, durationMs, formatted, unchanged, needsFormatting,")
+	fmt.Println("                                    errored, skipped}; every event has \"event\" and \"time\"")
+	fmt.Println("  --json-lines                      Stream one JSON object per file as prettier writes it: {\"path\", \"status\", \"durationMs\"};")
+	fmt.Println("                                    status is formatted, unchanged or error (with \"message\"), and no summary is printed")
+	fmt.Println("  --collapse-output                 Print only one summary line: formatted=12 unchanged=30 errored=0 skipped=3, or in check")
+	fmt.Println("                                    mode unformatted=.. formatted=.. errored=.. skipped=..; implies --quiet-prettier. With")
+	fmt.Println("                                    --report-format github the annotations come first")
+	fmt.Println("  --count-by-status                 After everything else, print pretti: formatted=.. unchanged=.. errored=.. skipped=..")
+	fmt.Println("                                    exit=N (unformatted=.. formatted=.. in check mode), even with --quiet;")
+	fmt.Println("                                    it goes to stderr when stdout carries a json, sarif or junit report")
+	fmt.Println("  --print0                          Print only the files prettier changed, or with --check the files that need")
+	fmt.Println("                                    formatting, each followed by a NUL byte instead of the summary, e.g. for")
+	fmt.Println("                                    xargs -0; list prints its files the same way")
+	fmt.Println("  --relative-to <dir>               Print file paths relative to <dir> in the text report, --print0 and list, e.g.")
+	fmt.Println("                                    --relative-to \"$(git rev-parse --show-toplevel)\" for repository-relative paths")
+	fmt.Println("  --group-by-dir                    Group the files in the text summary under their directories")
+	fmt.Println("  --limit-output-lines <n>          List at most n files in the text summary, grouped or not, followed by \"... and")
+	fmt.Println("                                    M more\"; the counts stay exact. Machine-readable reports are never cut")
+	fmt.Println("  --report-skipped-reasons          Report how many candidate files were skipped and why: missing, out-of-scope,")
+	fmt.Println("                                    symlink, excluded, ignored, other-extension, not-modified-recently, not-owned,")
+	fmt.Println("                                    empty, conflict-markers, no-content-match, outside-repository;")
+	fmt.Println("                                    --verbose lists them, --json adds \"skipped\"")
+	fmt.Println("  --print-skipped-ignored           After the summary, list the files skipped because an ignore file matched them,")
+	fmt.Println("                                    with the one that did: .prettiignore, .gitignore/.prettierignore (--all) or")
+	fmt.Println("                                    --respect-gitignore. Files prettier itself skips for .prettierignore are not seen")
+	fmt.Println("  --report-config-source            Print every option's resolved value and its source: command line, a PRETTI_*")
+	fmt.Println("                                    variable, .prettirc, the global config or the default, then exit without formatting")
+	fmt.Println("  --dump-selection-tree             Debug the selection: print every candidate file to stderr, grouped by directory, as kept")
+	fmt.Println("                                    or dropped with the reason and the filter (--exclude, .prettiignore, --ext, ...) that")
+	fmt.Println("                                    dropped it. Directories --all does not descend into are not listed")
+	fmt.Println("  --output-file <path>              Write the report (text, JSON or SARIF) to <path> instead of stdout")
+	fmt.Println("  --dry-run                         Print the prettier command that would run without running it")
+	fmt.Println("                                    With --json, print {\"dryRun\": true, \"mode\", \"files\", \"commands\"} instead, plus")
+	fmt.Println("                                    \"wouldChange\" with --dry-run-check; prettier still does not write anything")
+	fmt.Println("  --count-only                      Print only the number of selected files and exit without running prettier")
+	fmt.Println("  --empty-exit-code <n>             Exit with <n> instead of 0 when there are no files to format or check")
+	fmt.Println("  --stat                            With --dry-run, report how many files and lines prettier would change")
+	fmt.Println("  --report-diff-stats               With --check, show after each file that needs formatting how many lines prettier")
+	fmt.Println("                                    would add and remove, e.g. src/a.ts | +12 -9, to see which to fix first; --json")
+	fmt.Println("                                    adds \"diffStats\": {\"src/a.ts\": {\"added\": 12, \"removed\": 9}}. Formats each")
+	fmt.Println("                                    of those files a second time")
+	fmt.Println("  --dry-run-check                   With --dry-run, also check the files, list those that would change and exit 1 if")
+	fmt.Println("                                    there are any; still nothing is written. Plain --dry-run always exits 0")
+	fmt.Println("  --quiet                           Print none of pretti's own output on stdout when the run succeeds; on a non-zero exit")
+	fmt.Println("                                    what was held back is printed. Errors on stderr and prettier's output are not held back")
+	fmt.Println("  --summary-only-on-change          Print nothing and exit 0 when no file's content changed, e.g. in a hook on")
+	fmt.Println("                                    already clean code; otherwise print the usual summary. Implies --quiet-prettier")
+	fmt.Println("  --quiet-prettier                  Hide prettier's own stdout and stderr, e.g. its per-file lines; they are printed")
+	fmt.Println("                                    to stderr only if prettier fails. Combine with --quiet to silence both")
+	fmt.Println("  --verbose                         Print extra detail, including how long each prettier invocation took")
+	fmt.Println("  --slow-threshold <duration>       With --verbose, flag invocations slower than this and list their files (default 10s)")
+	fmt.Println("  --stats-json                      Print per-phase timing (selection, filtering, formatting) as JSON to stderr")
+	if *verbose {
+		// Only useful when working on pretti itself.
+		fmt.Println("  --profile <file>                  Write a pprof CPU profile of the run to <file>")
+	}
+	fmt.Println("  --prettier-path <path>            Run this prettier executable instead of the one found in PATH")
+	fmt.Println("  --runner <list>                   How to launch prettier: direct (the default), npx, pnpm, yarn or bunx;")
+	fmt.Println("                                    a comma-separated list such as pnpm,npx,direct tries each in order")
+	fmt.Println("                                    and uses the first that can run prettier --version")
+	fmt.Println("  --require-prettier-version <v>    Refuse to run unless prettier --version matches <v>: exact (3.3.3),")
+	fmt.Println("                                    caret (^3.3.0, same major) or tilde (~3.3.0, same minor), the way npm reads")
+	fmt.Println("                                    them (^0.2.0 is 0.2.x, ~3 is 3.x); also settable in .prettirc")
+	fmt.Println("  --write-versions-lock             After a successful run, write .pretti.lock next to .prettirc: prettier's version,")
+	fmt.Println("                                    the installed version of each --plugin package (a sha256 for plugin files) and")
+	fmt.Println("                                    a sha256 of the prettier config at the root (--config, .prettierrc*,")
+	fmt.Println("                                    prettier.config.*, .editorconfig). Commit it so the team formats alike")
+	fmt.Println("  --verify-versions-lock            Refuse to run, listing what differs, unless prettier, the plugins and the config")
+	fmt.Println("                                    match .pretti.lock; catches formatting drift before it turns into spurious diffs")
+	fmt.Println("  --config <path>                   Prettier config file to use; overrides $PRETTIER_CONFIG, which is used when unset")
+	fmt.Println("  --opt <key=value>                 Prettier option, e.g. --opt tabWidth=4 --opt singleQuote=true (repeatable)")
+	fmt.Println("  --plugin <name|path>              Prettier plugin to load, e.g. prettier-plugin-tailwindcss (repeatable;")
+	fmt.Println("                                    a \"plugin\" array in .prettirc works too); missing plugin files are warned about")
+	fmt.Println("  --auto-plugins                    When prettier cannot parse a .svelte or .astro file, retry it once with")
+	fmt.Println("                                    prettier-plugin-svelte or prettier-plugin-astro if that is installed in node_modules")
+	fmt.Println("  --command-template <template>     Write files with this command instead of prettier --write, e.g. a team wrapper:")
+	fmt.Println("                                    --command-template \"./scripts/fmt --fix {files}\". {bin} is the command that")
+	fmt.Println("                                    launches prettier (see --runner), {args} the options pretti would pass it")
+	fmt.Println("                                    (--config, --opt, --plugin, ...) and {files} the batch of files, which is required.")
+	fmt.Println("                                    Checking still runs prettier, so it cannot be used with --check. --dry-run prints it")
+	fmt.Println("  --post-format-command <command>   Run <command> once after a write run succeeds, e.g. \"git add -u\" (shell-style")
+	fmt.Println("                                    quoting, no shell: use sh -c '...' for pipes). PRETTI_FORMATTED holds the files")
+	fmt.Println("                                    whose content changed, one per line. Not run after --check, --dry-run or a failed")
+	fmt.Println("                                    run; if it fails pretti exits 1. Also settable in .prettirc as post-format-command")
+	fmt.Println("  --prettier-args <args>            Extra arguments inserted before the file list on every prettier run, e.g.")
+	fmt.Println("                                    --prettier-args \"--log-level warn --ignore-path 'my ignore'\" (shell-style quoting)")
+	fmt.Println("  --no-prettier-color               Pass --no-color to prettier; this already happens when stdout is not a terminal")
+	fmt.Println("                                    or NO_COLOR is set")
+	fmt.Println("  --prettier-cache                  Pass --cache to prettier so it skips files unchanged since its last run")
+	fmt.Println("  --prettier-cache-location <path>  Prettier cache file, used only with --prettier-cache (default: prettier-cache in the")
+	fmt.Println("                                    repository's git directory, keeping it out of the working tree; outside a repository,")
+	fmt.Println("                                    prettier's node_modules/.cache/prettier/.prettier-cache)")
+	fmt.Println("  --require-pragma                  Pass --require-pragma to prettier: files without a @format or @prettier comment at")
+	fmt.Println("                                    the top are still selected, but prettier itself leaves them alone, so they are")
+	fmt.Println("                                    reported unchanged (or formatted with --check)")
+	fmt.Println("  --insert-pragma                   Pass --insert-pragma to prettier: every file it formats gets a @format comment, so")
+	fmt.Println("                                    --require-pragma picks it up from then on. With --check, files without one need")
+	fmt.Println("                                    formatting")
 }
@@ -0,0 +1,1103 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain runs pretti itself instead of the tests when runPretti starts the
+// test binary, so the tests can check whole runs.
+func TestMain(m *testing.M) {
+	if os.Getenv("GO_PRETTI_MAIN") == "1" {
+		os.Args = append([]string{"pretti"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakePrettier is a prettier stand-in for runPretti: --write strips trailing
+// spaces from each file, --list-different lists the files that have any, and
+// with neither it prints the files without them.
+// It appends its arguments to $FAKE_PRETTIER_LOG, one run per line.
+const fakePrettier = `#!/bin/sh
+if [ -n "$FAKE_PRETTIER_LOG" ]; then echo "$*" >> "$FAKE_PRETTIER_LOG"; fi
+mode= skip= status=0
+for a; do
+	if [ -n "$skip" ]; then skip=; continue; fi
+	case $a in
+	--version) echo 3.3.3; exit 0 ;;
+	--write|--list-different) mode=$a ;;
+	--cache-location|--config|--parser|--plugin|--ignore-path|--log-level) skip=1 ;;
+	-*) ;;
+	*)
+		if [ "$mode" = --write ]; then
+			sed -i 's/ *$//' "$a"
+			echo "$a 5ms"
+		elif [ -z "$mode" ]; then
+			sed 's/ *$//' "$a"
+		elif grep -q ' $' "$a"; then
+			echo "$a"
+			status=1
+		fi ;;
+	esac
+done
+exit $status
+`
+
+// prettiRun is the outcome of runPretti.
+type prettiRun struct {
+	stdout, stderr string
+	code           int
+	// prettier holds fakePrettier's arguments, one run per line.
+	prettier string
+}
+
+// runPretti runs pretti with args in dir, using fakePrettier, with no
+// PRETTI_ variables, CI environment or global config.
+func runPretti(t *testing.T, dir string, args ...string) prettiRun {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "prettier")
+	if err := os.WriteFile(bin, []byte(fakePrettier), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	prettierLog := filepath.Join(t.TempDir(), "prettier.log")
+	cmd := exec.Command(os.Args[0], append([]string{"--prettier-path", bin}, args...)...)
+	cmd.Dir = dir
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "PRETTI_") && !strings.HasPrefix(kv, "GITHUB_") && !strings.HasPrefix(kv, "CI=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, "GO_PRETTI_MAIN=1", "XDG_CONFIG_HOME="+t.TempDir(), "FAKE_PRETTIER_LOG="+prettierLog)
+	var out, errOut strings.Builder
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	run := prettiRun{}
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		run.code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.ReadFile(prettierLog)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	run.stdout, run.stderr, run.prettier = out.String(), errOut.String(), string(log)
+	return run
+}
+
+// setFlag sets the named flag for the rest of the test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	old := f.Value.String()
+	if err := f.Value.Set(value); err != nil {
+		t.Fatalf("setting --%s: %v", name, err)
+	}
+	t.Cleanup(func() { f.Value.Set(old) })
+}
+
+// chdir changes the working directory for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+}
+
+// writeFile writes content to path, creating its directories.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stat stats path, or returns nil if it does not exist.
+func stat(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestSymlinkFilter(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.ts"), "a\n")
+	if err := os.Symlink("a.ts", filepath.Join(dir, "link.ts")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	tests := []struct {
+		name string
+		keep bool
+	}{
+		{"a.ts", true},
+		{"link.ts", false},
+		{"missing.ts", true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if got := symlinkFilter(path, stat(t, path)); got != tt.keep {
+			t.Errorf("symlinkFilter(%s) = %v, want %v", tt.name, got, tt.keep)
+		}
+	}
+}
+
+func TestIncludeDirsFilter(t *testing.T) {
+	root := t.TempDir()
+	keep := includeDirsFilter([]string{filepath.Join(root, "src"), filepath.Join(root, "packages", "web")})
+	tests := []struct {
+		path string
+		keep bool
+	}{
+		{"src/a.ts", true},
+		{"src/deep/b.ts", true},
+		{"src", true},
+		{"srcs/a.ts", false},
+		{"packages/web/c.ts", true},
+		{"packages/api/c.ts", false},
+		{"d.ts", false},
+	}
+	for _, tt := range tests {
+		if got := keep(filepath.Join(root, tt.path), nil); got != tt.keep {
+			t.Errorf("includeDirsFilter(%s) = %v, want %v", tt.path, got, tt.keep)
+		}
+	}
+}
+
+func TestExcludeFilter(t *testing.T) {
+	keep := excludeFilter("root", []string{"dist", "*.snap", "src/gen/*"})
+	tests := []struct {
+		path string
+		keep bool
+	}{
+		{"root/src/a.ts", true},
+		{"root/dist/a.js", false},
+		{"root/packages/web/dist/a.js", false},
+		{"root/src/__snapshots__/a.snap", false},
+		{"root/src/gen/types.ts", false},
+		{"root/lib/src/gen/types.ts", true},
+		{"root/distance.ts", true},
+	}
+	for _, tt := range tests {
+		if got := keep(tt.path, nil); got != tt.keep {
+			t.Errorf("excludeFilter(%s) = %v, want %v", tt.path, got, tt.keep)
+		}
+	}
+}
+
+func TestExtFilter(t *testing.T) {
+	parserOverrides.Store("config/app.conf", "json")
+	t.Cleanup(func() { parserOverrides.Delete("config/app.conf") })
+	tests := []struct {
+		exts []string
+		path string
+		keep bool
+	}{
+		{[]string{".ts", ".json"}, "src/a.ts", true},
+		{[]string{".ts", ".json"}, "c.json", true},
+		{[]string{".ts", ".json"}, "src/a.tsx", false},
+		{[]string{".ts", ".json"}, "README", false},
+		{[]string{".ts", ".json"}, "config/app.conf", true},
+		{[]string{".d.ts"}, "types/a.d.ts", true},
+		{[]string{".d.ts"}, "src/a.ts", false},
+		{[]string{""}, "README", true},
+		{nil, "src/a.ts", false},
+	}
+	for _, tt := range tests {
+		if got := extFilter(tt.exts)(tt.path, nil); got != tt.keep {
+			t.Errorf("extFilter(%q)(%s) = %v, want %v", tt.exts, tt.path, got, tt.keep)
+		}
+	}
+}
+
+func TestNonEmptyFilter(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "full.ts"), "a\n")
+	writeFile(t, filepath.Join(dir, "empty.ts"), "")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		keep bool
+	}{
+		{"full.ts", true},
+		{"empty.ts", false},
+		{"sub", true},
+		// "missing.ts" has no FileInfo, which keeps it for prettier to
+		// report.
+		{"missing.ts", true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if got := nonEmptyFilter(path, stat(t, path)); got != tt.keep {
+			t.Errorf("nonEmptyFilter(%s) = %v, want %v", tt.name, got, tt.keep)
+		}
+	}
+}
+
+func TestConflictFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		keep    bool
+	}{
+		{"plain", "const a = 1;\n", true},
+		{"conflict", "<<<<<<< HEAD\na\n=======\nb\n>>>>>>> topic\n", false},
+		{"crlf conflict", "<<<<<<< HEAD\r\na\r\n=======\r\nb\r\n>>>>>>> topic\r\n", false},
+		{"bare markers", "<<<<<<<\na\n>>>>>>>\n", false},
+		{"heading underline", "Title\n=======\n", true},
+		{"open only", "<<<<<<< HEAD\na\n", true},
+		{"close before open", ">>>>>>> topic\n<<<<<<< HEAD\n", true},
+		{"longer run", "<<<<<<<< x\n>>>>>>>> y\n", true},
+		{"indented", "  <<<<<<< HEAD\n  >>>>>>> topic\n", true},
+		{"binary", "\x00<<<<<<< HEAD\n>>>>>>> topic\n", true},
+	}
+	dir := t.TempDir()
+	for i, tt := range tests {
+		path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".ts")
+		writeFile(t, path, tt.content)
+		if got := conflictFilter(path, nil); got != tt.keep {
+			t.Errorf("%d: conflictFilter(%s) = %v, want %v", i, tt.name, got, tt.keep)
+		}
+	}
+	if !conflictFilter(filepath.Join(dir, "missing.ts"), nil) {
+		t.Error("conflictFilter dropped a missing file; it should be left for prettier")
+	}
+}
+
+func TestContentFilter(t *testing.T) {
+	keep := contentFilter(regexp.MustCompile(`@format\b`))
+	tests := []struct {
+		name    string
+		content string
+		keep    bool
+	}{
+		{"pragma", "/** @format */\nconst a = 1;\n", true},
+		{"none", "const a = 1;\n", false},
+		{"prefix only", "// @formatter:off\n", false},
+		{"binary", "\x00/** @format */\n", false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".ts")
+		writeFile(t, path, tt.content)
+		if got := keep(path, nil); got != tt.keep {
+			t.Errorf("contentFilter(%s) = %v, want %v", tt.name, got, tt.keep)
+		}
+	}
+	if keep(filepath.Join(dir, "missing.ts"), nil) {
+		t.Error("contentFilter kept a file it could not read")
+	}
+}
+
+func TestCodeownersFilter(t *testing.T) {
+	base := t.TempDir()
+	rules := parseCodeowners(`
+# Default owners
+*       @org/core
+*.ts    @org/web
+/docs/  @Docs-Team
+/build/*  @org/infra
+/vendor/
+`)
+	tests := []struct {
+		owner, path string
+		keep        bool
+	}{
+		{"@org/core", "README.md", true},
+		{"@org/core", "src/a.ts", false},
+		{"@org/web", "src/a.ts", true},
+		{"org/web", "src/a.ts", true},
+		{"@docs-team", "docs/guide/intro.md", true},
+		{"@org/core", "docs/guide/intro.md", false},
+		{"@org/infra", "build/out.js", true},
+		{"@org/infra", "build/sub/out.js", false},
+		{"@org/core", "build/sub/out.js", true},
+		{"@org/core", "vendor/lib.js", false},
+	}
+	for _, tt := range tests {
+		keep := codeownersFilter(base, rules, tt.owner)
+		if got := keep(filepath.Join(base, tt.path), nil); got != tt.keep {
+			t.Errorf("codeownersFilter(%s)(%s) = %v, want %v", tt.owner, tt.path, got, tt.keep)
+		}
+	}
+}
+
+func TestPrettiIgnoreFilter(t *testing.T) {
+	base := t.TempDir()
+	keep := prettiIgnoreFilter(base, parseIgnoreRules("generated/\n*.min.js\n!keep.min.js\n/fixtures\n"))
+	tests := []struct {
+		path string
+		keep bool
+	}{
+		{"src/a.ts", true},
+		{"src/generated/a.ts", false},
+		{"lib/app.min.js", false},
+		{"lib/keep.min.js", true},
+		{"fixtures/a.json", false},
+		{"test/fixtures/a.json", true},
+	}
+	for _, tt := range tests {
+		if got := keep(filepath.Join(base, tt.path), nil); got != tt.keep {
+			t.Errorf("prettiIgnoreFilter(%s) = %v, want %v", tt.path, got, tt.keep)
+		}
+	}
+}
+
+func TestSelectionFiltersMissingCodeowners(t *testing.T) {
+	chdir(t, t.TempDir())
+	setFlag(t, "codeowners", "@org/web")
+	if _, err := selectionFilters([]string{".ts"}, ".", nil); err == nil || !strings.Contains(err.Error(), "no CODEOWNERS file") {
+		t.Errorf("selectionFilters without a CODEOWNERS file: got error %v", err)
+	}
+}
+
+func TestFilterFiles(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	writeFile(t, "src/a.ts", "a\n")
+	writeFile(t, "src/b.md", "b\n")
+	writeFile(t, "dist/c.ts", "c\n")
+	filters, err := selectionFilters([]string{".ts"}, ".", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := filterFiles([]string{"src/a.ts", "src/b.md", "dist/c.ts", "src/gone.ts"}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "src/a.ts" {
+		t.Errorf("filterFiles kept %q, want [src/a.ts]", got)
+	}
+}
+
+// isolateFlags gives the test a flag set of its own, so what it sets does not
+// count as given on the command line in later tests, and restores the flag
+// values, config and configSources afterwards.
+func isolateFlags(t *testing.T) *flag.FlagSet {
+	t.Helper()
+	saved := flag.CommandLine
+	fresh := flag.NewFlagSet(saved.Name(), flag.ContinueOnError)
+	values := map[string]string{}
+	lists := map[string]stringList{}
+	saved.VisitAll(func(f *flag.Flag) {
+		fresh.Var(f.Value, f.Name, f.Usage)
+		if l, ok := f.Value.(*stringList); ok {
+			lists[f.Name] = slices.Clone(*l)
+		} else {
+			values[f.Name] = f.Value.String()
+		}
+	})
+	flag.CommandLine = fresh
+	savedConfig, savedSources := config, configSources
+	config, configSources = Config{}, map[string]string{}
+	t.Cleanup(func() {
+		flag.CommandLine = saved
+		saved.VisitAll(func(f *flag.Flag) {
+			if l, ok := f.Value.(*stringList); ok {
+				*l = lists[f.Name]
+			} else if f.Value.String() != values[f.Name] {
+				f.Value.Set(values[f.Name])
+			}
+		})
+		config, configSources = savedConfig, savedSources
+	})
+	return fresh
+}
+
+// configFixture sets up a global config and a .prettirc in temporary
+// directories, either left out when its content is "", and makes the project
+// directory the working directory. It returns the two paths.
+func configFixture(t *testing.T, global, project string) (string, string) {
+	t.Helper()
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	globalPath := filepath.Join(xdg, "pretti", "config.json")
+	if global != "" {
+		writeFile(t, globalPath, global)
+	}
+	dir := t.TempDir()
+	chdir(t, dir)
+	projectPath := filepath.Join(".", configFile)
+	if project != "" {
+		writeFile(t, projectPath, project)
+	}
+	return globalPath, projectPath
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		// option is the flag under test, report-format if empty.
+		option          string
+		global, project string
+		env, cmdline    string
+		want, source    string
+	}{
+		{name: "default", want: "text"},
+		{name: "global config", global: `{"report-format": "json"}`, want: "json", source: "global"},
+		{name: "project over global", global: `{"report-format": "json"}`, project: `{"report-format": "sarif"}`, want: "sarif", source: "project"},
+		{name: "project alone", project: `{"report-format": "sarif"}`, want: "sarif", source: "project"},
+		{name: "environment over project", global: `{"report-format": "json"}`, project: `{"report-format": "sarif"}`, env: "junit", want: "junit", source: "environment"},
+		{name: "command line over all", global: `{"report-format": "json"}`, project: `{"report-format": "sarif"}`, env: "junit", cmdline: "github", want: "github", source: "command line"},
+		{name: "command line over environment", env: "junit", cmdline: "github", want: "github", source: "command line"},
+		{name: "list from global config", option: "plugin", global: `{"plugin": ["g1", "g2"]}`, want: "g1,g2", source: "global"},
+		{name: "list project replaces global", option: "plugin", global: `{"plugin": ["g1", "g2"]}`, project: `{"plugin": ["p"]}`, want: "p", source: "project"},
+		{name: "list environment replaces project", option: "plugin", global: `{"plugin": ["g1"]}`, project: `{"plugin": ["p"]}`, env: "e", want: "e", source: "environment"},
+		{name: "list command line replaces all", option: "plugin", global: `{"plugin": ["g1"]}`, project: `{"plugin": ["p"]}`, env: "e", cmdline: "c", want: "c", source: "command line"},
+		{name: "opt project replaces global", option: "opt", global: `{"opt": ["tabWidth=4", "semi=false"]}`, project: `{"opt": "printWidth=100"}`, want: "printWidth=100", source: "project"},
+		{name: "opt environment replaces project", option: "opt", project: `{"opt": ["printWidth=100"]}`, env: "tabWidth=2", want: "tabWidth=2", source: "environment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			option := cmp.Or(tt.option, "report-format")
+			env := "PRETTI_" + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+			fs := isolateFlags(t)
+			globalPath, projectPath := configFixture(t, tt.global, tt.project)
+			if tt.env != "" {
+				t.Setenv(env, tt.env)
+			}
+			if tt.cmdline != "" {
+				if err := fs.Set(option, tt.cmdline); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := loadConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if got := fs.Lookup(option).Value.String(); got != tt.want {
+				t.Errorf("%s = %q, want %q", option, got, tt.want)
+			}
+			want := map[string]string{
+				"":             "",
+				"global":       "global config " + globalPath,
+				"project":      "project config " + projectPath,
+				"environment":  "environment " + env,
+				"command line": "command line",
+			}[tt.source]
+			if got := configSources[option]; got != want {
+				t.Errorf("source = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMerges(t *testing.T) {
+	isolateFlags(t)
+	configFixture(t,
+		`{"exclude": ["*.snap"], "ext_aliases": {"web": [".ts"], "docs": [".md"]}, "jobs": 2}`,
+		`{"exclude": ["fixtures"], "ext_aliases": {"web": [".ts", ".css"]}}`)
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// Excludes add up, lowest precedence first.
+	if got := strings.Join(config.Exclude, ","); got != "*.snap,fixtures" {
+		t.Errorf("exclude = %s, want *.snap,fixtures", got)
+	}
+	// .prettirc redefines an alias and leaves the others alone.
+	if got := strings.Join(config.ExtAliases["web"], ","); got != ".ts,.css" {
+		t.Errorf("@web = %s, want .ts,.css", got)
+	}
+	if got := strings.Join(config.ExtAliases["docs"], ","); got != ".md" {
+		t.Errorf("@docs = %s, want .md", got)
+	}
+	// A flag only the global config sets keeps its value.
+	if *jobs != 2 {
+		t.Errorf("jobs = %d, want 2", *jobs)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, project, env, want string
+	}{
+		{"unknown key", `{"jobz": 2}`, "", `unknown option "jobz"`},
+		{"bad value", `{"jobs": "many"}`, "", `jobs: invalid value "many"`},
+		{"bad environment", "", "many", "PRETTI_JOBS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateFlags(t)
+			configFixture(t, "", tt.project)
+			if tt.env != "" {
+				t.Setenv("PRETTI_JOBS", tt.env)
+			}
+			if err := loadConfig(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig error = %v, want one mentioning %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagWasSetOutsideCommandLine(t *testing.T) {
+	// run rejects --batch-size 0 only when flagWasSet says it was given, so
+	// a 0 from .prettirc or the environment must count too.
+	tests := []struct {
+		name, project, env string
+		set                bool
+	}{
+		{name: "default"},
+		{name: "project config", project: `{"batch-size": 0}`, set: true},
+		{name: "environment", env: "0", set: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateFlags(t)
+			configFixture(t, "", tt.project)
+			if tt.env != "" {
+				t.Setenv("PRETTI_BATCH_SIZE", tt.env)
+			}
+			if err := loadConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if got := flagWasSet("batch-size"); got != tt.set || *batchSize != 0 {
+				t.Errorf("flagWasSet(batch-size) = %v with --batch-size %d, want %v with 0", got, *batchSize, tt.set)
+			}
+		})
+	}
+}
+
+// git runs git in dir with a fixed identity and fails the test if it fails.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=pretti", "GIT_AUTHOR_EMAIL=pretti@example.com",
+		"GIT_COMMITTER_NAME=pretti", "GIT_COMMITTER_EMAIL=pretti@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// gitRepo creates a git repository in a temporary directory and changes into
+// it for the rest of the test.
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "init", "-q")
+	chdir(t, dir)
+	return dir
+}
+
+func TestWalkFilesIgnoreFiles(t *testing.T) {
+	dir := gitRepo(t)
+	writeFile(t, ".gitignore", "*.ts\n!keep.ts\n/out\ntmp/\n")
+	writeFile(t, "src/.gitignore", "x.js\n")
+	writeFile(t, "src/gen/.gitignore", "!x.js\n")
+	writeFile(t, ".prettierignore", "legacy.js\n")
+	writeFile(t, ".git/info/exclude", "local.js\n")
+	for _, name := range []string{
+		"a.js", "a.ts", "keep.ts", "legacy.js", "local.js",
+		"src/x.js", "src/y.js", "src/gen/x.js",
+		"out/o.js", "lib/out/o.js", "tmp/t.js", "lib/tmp",
+	} {
+		writeFile(t, filepath.Join(dir, name), "a\n")
+	}
+	want := []string{
+		".gitignore", ".prettierignore", "a.js", "keep.ts", "lib/out/o.js", "lib/tmp",
+		"src/.gitignore", "src/gen/.gitignore", "src/gen/x.js", "src/y.js",
+	}
+	got, err := walkFiles(".", []string{""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("walkFiles = %q, want %q", got, want)
+	}
+
+	setFlag(t, "no-ignore", "true")
+	got, err = walkFiles(".", []string{""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 16 {
+		t.Errorf("walkFiles with --no-ignore = %q, want all 16 files", got)
+	}
+}
+
+func TestWalkFilesIgnoreFilesInSubdirectory(t *testing.T) {
+	dir := gitRepo(t)
+	writeFile(t, ".gitignore", "/sub/skip.js\n*.log.js\n")
+	writeFile(t, "sub/keep.js", "a\n")
+	writeFile(t, "sub/skip.js", "a\n")
+	writeFile(t, "sub/a.log.js", "a\n")
+	chdir(t, filepath.Join(dir, "sub"))
+	got, err := walkFiles(".", []string{".js"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"keep.js"}; !slices.Equal(got, want) {
+		t.Errorf("walkFiles = %q, want %q", got, want)
+	}
+}
+
+func TestParseIgnoreRules(t *testing.T) {
+	rules := parseIgnoreRules("# comment\n\n*.ts\r\n!keep.ts\n/dist\nbuild/\ntrailing  \nspace\\ \n\\#hash\n\\!bang\n")
+	want := []ignoreRule{
+		{segments: []string{"**", "*.ts"}},
+		{segments: []string{"**", "keep.ts"}, negate: true},
+		{segments: []string{"dist"}},
+		{segments: []string{"**", "build"}, dirOnly: true},
+		{segments: []string{"**", "trailing"}},
+		{segments: []string{"**", "space\\ "}},
+		{segments: []string{"**", "#hash"}},
+		{segments: []string{"**", "!bang"}},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("parseIgnoreRules = %+v, want %+v", rules, want)
+	}
+	for i, rule := range rules {
+		if !slices.Equal(rule.segments, want[i].segments) || rule.negate != want[i].negate || rule.dirOnly != want[i].dirOnly {
+			t.Errorf("rule %d = %+v, want %+v", i, rule, want[i])
+		}
+	}
+}
+
+func TestIgnoredBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{"negation after glob", "*.ts\n!keep.ts\n", "keep.ts", false, false},
+		{"negation after glob, nested", "*.ts\n!keep.ts\n", "src/keep.ts", false, false},
+		{"glob still ignores", "*.ts\n!keep.ts\n", "other.ts", false, true},
+		{"negation before glob loses", "!keep.ts\n*.ts\n", "keep.ts", false, true},
+		{"anchored at root", "/dist\n", "dist", true, true},
+		{"anchored below root", "/dist\n", "a/dist", true, false},
+		{"anchored, file below", "/dist\n", "dist/a.js", false, true},
+		{"anchored, file below nested", "/dist\n", "a/dist/a.js", false, false},
+		{"dir-only on a directory", "build/\n", "build", true, true},
+		{"dir-only on a nested directory", "build/\n", "a/build", true, true},
+		{"dir-only on a file", "build/\n", "build", false, false},
+		{"dir-only, file inside", "build/\n", "build/out.js", false, true},
+		{"re-include inside ignored dir", "build/\n!build/keep.js\n", "build/keep.js", false, true},
+		{"re-include with dir contents glob", "build/*\n!build/keep.js\n", "build/keep.js", false, false},
+		{"dir contents glob", "build/*\n!build/keep.js\n", "build/other.js", false, true},
+		{"middle slash anchors", "docs/*.md\n", "a/docs/x.md", false, false},
+		{"double star", "**/gen/**\n", "a/gen/b/c.js", false, true},
+		{"no match", "*.ts\n", "a.js", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ignoredBy(parseIgnoreRules(tt.rules), tt.rel, tt.isDir); got != tt.ignored {
+				t.Errorf("ignoredBy(%q, %q, %v) = %v, want %v", tt.rules, tt.rel, tt.isDir, got, tt.ignored)
+			}
+		})
+	}
+}
+
+func TestWorktreeStateFiles(t *testing.T) {
+	dir := gitRepo(t)
+	writeFile(t, "a.js", "a\n")
+	git(t, dir, "add", "a.js")
+	git(t, dir, "commit", "-qm", "a")
+	wt := filepath.Join(t.TempDir(), "wt")
+	git(t, dir, "worktree", "add", "-q", wt)
+	wtGitDir := filepath.Join(dir, ".git", "worktrees", "wt")
+	writeFile(t, filepath.Join(wt, "a.js"), "a  \n")
+
+	run := runPretti(t, wt, "--current", "--prettier-cache")
+	if run.code != 0 {
+		t.Fatalf("pretti --current exited %d:\n%s%s", run.code, run.stdout, run.stderr)
+	}
+	if data, err := os.ReadFile(filepath.Join(wt, "a.js")); err != nil || string(data) != "a\n" {
+		t.Errorf("a.js in the worktree = %q, %v; want it formatted", data, err)
+	}
+	if stat(t, filepath.Join(wtGitDir, runStateFile)) == nil {
+		t.Errorf("no %s in the worktree's git dir %s", runStateFile, wtGitDir)
+	}
+	if stat(t, filepath.Join(dir, ".git", runStateFile)) != nil {
+		t.Errorf("%s written to the main repository's .git", runStateFile)
+	}
+	if want := "--cache-location " + filepath.Join(wtGitDir, "prettier-cache"); !strings.Contains(run.prettier, want) {
+		t.Errorf("prettier ran with %q, want %s", run.prettier, want)
+	}
+}
+
+func TestWalkFilesMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	for _, name := range []string{"a.js", "b/b.js", "b/c/c.js", "b/c/d/d.js", "e/e.js"} {
+		writeFile(t, name, "a\n")
+	}
+	tests := []struct {
+		depth string
+		want  []string
+	}{
+		{"0", []string{"a.js"}},
+		{"1", []string{"a.js", "b/b.js", "e/e.js"}},
+		{"2", []string{"a.js", "b/b.js", "b/c/c.js", "e/e.js"}},
+		{"3", []string{"a.js", "b/b.js", "b/c/c.js", "b/c/d/d.js", "e/e.js"}},
+		{"-1", []string{"a.js", "b/b.js", "b/c/c.js", "b/c/d/d.js", "e/e.js"}},
+	}
+	for _, tt := range tests {
+		setFlag(t, "max-depth", tt.depth)
+		got, err := walkFiles(".", []string{".js"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("walkFiles with --max-depth %s = %q, want %q", tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestModifiedAfterFilter(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.Local)
+	mtimes := map[string]time.Time{
+		"old.js":    now.Add(-30 * 24 * time.Hour),
+		"week.js":   now.Add(-3 * 24 * time.Hour),
+		"hour.js":   now.Add(-30 * time.Minute),
+		"cutoff.js": time.Date(2024, 6, 10, 0, 0, 0, 0, time.Local),
+	}
+	for name, mtime := range mtimes {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, "a\n")
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		value string
+		kept  []string
+	}{
+		{"1h", []string{"hour.js"}},
+		{"96h", []string{"hour.js", "week.js"}},
+		{"168h", []string{"cutoff.js", "hour.js", "week.js"}},
+		{"2024-06-10", []string{"cutoff.js", "hour.js", "week.js"}},
+		{"2024-06-10T00:00:01" + now.Format("Z07:00"), []string{"hour.js", "week.js"}},
+		{"2024-01-01T00:00:00Z", []string{"cutoff.js", "hour.js", "old.js", "week.js"}},
+	}
+	for _, tt := range tests {
+		cutoff, err := parseCutoff(tt.value, now)
+		if err != nil {
+			t.Fatalf("parseCutoff(%q): %v", tt.value, err)
+		}
+		keep := modifiedAfterFilter(cutoff)
+		var kept []string
+		for _, name := range slices.Sorted(maps.Keys(mtimes)) {
+			path := filepath.Join(dir, name)
+			if keep(path, stat(t, path)) {
+				kept = append(kept, name)
+			}
+		}
+		if !slices.Equal(kept, tt.kept) {
+			t.Errorf("--modified-after %s kept %q, want %q", tt.value, kept, tt.kept)
+		}
+	}
+	if !modifiedAfterFilter(now)(filepath.Join(dir, "old.js"), nil) {
+		t.Error("modifiedAfterFilter dropped a file it could not stat")
+	}
+	if _, err := parseCutoff("last week", now); err == nil {
+		t.Error(`parseCutoff("last week") succeeded, want an error`)
+	}
+}
+
+func TestWalkFilesNestedRepos(t *testing.T) {
+	dir := gitRepo(t)
+	writeFile(t, "a.js", "a\n")
+	writeFile(t, "clone/b.js", "a\n")
+	git(t, filepath.Join(dir, "clone"), "init", "-q")
+	writeFile(t, "clone/.git/x.js", "a\n")
+	// A submodule or worktree has a .git file rather than a directory.
+	writeFile(t, "module/c.js", "a\n")
+	writeFile(t, "module/.git", "gitdir: ../.git/modules/module\n")
+
+	got, err := walkFiles(".", []string{".js"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.js"}; !slices.Equal(got, want) {
+		t.Errorf("walkFiles = %q, want %q", got, want)
+	}
+
+	setFlag(t, "include-nested-repos", "true")
+	got, err = walkFiles(".", []string{".js"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.js", "clone/b.js", "module/c.js"}; !slices.Equal(got, want) {
+		t.Errorf("walkFiles with --include-nested-repos = %q, want %q", got, want)
+	}
+}
+
+func TestResolveSymlinks(t *testing.T) {
+	dir := gitRepo(t)
+	outside := t.TempDir()
+	writeFile(t, "a.js", "a  \n")
+	writeFile(t, "real/r.js", "r  \n")
+	writeFile(t, filepath.Join(outside, "o.js"), "o  \n")
+	writeFile(t, filepath.Join(outside, "dir/d.js"), "d  \n")
+	for link, target := range map[string]string{
+		"l1.js":  "a.js",
+		"l2.js":  "a.js",
+		"linked": "real",
+		"out.js": filepath.Join(outside, "o.js"),
+		"outdir": filepath.Join(outside, "dir"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Skip("symlinks not supported:", err)
+		}
+	}
+
+	run := runPretti(t, dir, "--all", "--yes", "--resolve-symlinks", "--ext", ".js", "--report-skipped-reasons")
+	if run.code != 0 {
+		t.Fatalf("pretti --all --resolve-symlinks exited %d:\n%s%s", run.code, run.stdout, run.stderr)
+	}
+	if !strings.Contains(run.stdout, "2 outside-repository") {
+		t.Errorf("pretti did not report skipping out.js and outdir/d.js as outside the repository:\n%s", run.stdout)
+	}
+	var files []string
+	for _, arg := range strings.Fields(run.prettier) {
+		if !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+		}
+	}
+	slices.Sort(files)
+	if want := []string{"a.js", "real/r.js"}; !slices.Equal(files, want) {
+		t.Errorf("prettier formatted %q, want %q", files, want)
+	}
+	for name, content := range map[string]string{"o.js": "o  \n", "dir/d.js": "d  \n"} {
+		if data, err := os.ReadFile(filepath.Join(outside, name)); err != nil || string(data) != content {
+			t.Errorf("%s outside the repository = %q, %v; want it left alone", name, data, err)
+		}
+	}
+	if data, err := os.ReadFile("a.js"); err != nil || string(data) != "a\n" {
+		t.Errorf("a.js = %q, %v; want it formatted", data, err)
+	}
+}
+
+func TestGitDiffFilesDeletionsAndRenames(t *testing.T) {
+	dir := gitRepo(t)
+	writeFile(t, "kept.js", "a\n")
+	writeFile(t, "deleted.js", "b\n")
+	writeFile(t, "old.js", "the same content, so git pairs it as a rename\n")
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "-qm", "base")
+	writeFile(t, "kept.js", "a  \n")
+	git(t, dir, "rm", "-q", "deleted.js")
+	git(t, dir, "mv", "old.js", "new.js")
+	git(t, dir, "add", "kept.js")
+	status := git(t, dir, "status", "--porcelain")
+	for _, entry := range []string{"D  deleted.js", "R  old.js -> new.js", "M  kept.js"} {
+		if !strings.Contains(status, entry) {
+			t.Fatalf("git status --porcelain = %q, want an entry %q", status, entry)
+		}
+	}
+	want := []string{filepath.Join(dir, "kept.js"), filepath.Join(dir, "new.js")}
+
+	got, err := getStagedFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("getStagedFiles = %q, want %q", got, want)
+	}
+
+	git(t, dir, "commit", "-qm", "change")
+	got, err = getChangedFilesAgainst(dir, "HEAD~1", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("getChangedFilesAgainst(HEAD~1, HEAD) = %q, want %q", got, want)
+	}
+
+	// Without rename detection git reports the new path as added.
+	git(t, dir, "config", "diff.renames", "false")
+	got, err = getChangedFilesAgainst(dir, "HEAD~1", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("getChangedFilesAgainst(HEAD~1, HEAD) with diff.renames off = %q, want %q", got, want)
+	}
+
+	// The check only fails on kept.js, not on the deleted or old path.
+	run := runPretti(t, dir, "--base", "HEAD~1", "--check")
+	if run.code != 1 || !strings.HasSuffix(run.prettier, " "+strings.Join(want, " ")+"\n") {
+		t.Errorf("pretti --base HEAD~1 --check exited %d and ran prettier with %q, want exit 1 checking kept.js and new.js:\n%s%s",
+			run.code, run.prettier, run.stdout, run.stderr)
+	}
+}
+
+func TestPrint0RelativeTo(t *testing.T) {
+	dir := gitRepo(t)
+	writeFile(t, "top.js", "t  \n")
+	writeFile(t, "sub/a.js", "a  \n")
+	writeFile(t, "sub/clean.js", "c\n")
+	writeFile(t, "sub/with space.js", "s  \n")
+
+	run := runPretti(t, dir, "--all", "--yes", "--print0", "--relative-to", "sub")
+	if want := "a.js\x00with space.js\x00../top.js\x00"; run.code != 0 || run.stdout != want {
+		t.Errorf("pretti --print0 --relative-to sub exited %d and printed %q, want %q:\n%s", run.code, run.stdout, want, run.stderr)
+	}
+
+	writeFile(t, "top.js", "t  \n")
+	writeFile(t, "sub/a.js", "a  \n")
+	run = runPretti(t, dir, "--all", "--print0", "--check", "--relative-to", filepath.Join(dir, "sub"))
+	if want := "a.js\x00../top.js\x00"; run.code != 1 || run.stdout != want {
+		t.Errorf("pretti --print0 --check --relative-to sub exited %d and printed %q, want %q:\n%s", run.code, run.stdout, want, run.stderr)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"a b c", "a b c", 0},
+		{"a b c", "a c", 1},
+		{"a c", "a b c", 1},
+		{"a b c", "a x c", 2},
+		{"a b c", "", 3},
+		{"a b c d", "d c b a", 6},
+	}
+	for _, tt := range tests {
+		a, b := strings.Fields(tt.a), strings.Fields(tt.b)
+		if got := editDistance(a, b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := len(myersTrace(a, b)) - 1; got != tt.want {
+			t.Errorf("myersTrace(%q, %q) ends after %d edits, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckOnlyChangedLinesPartiallyStaged(t *testing.T) {
+	dir := gitRepo(t)
+	writeFile(t, "a.js", "a\nb\n")
+	writeFile(t, "b.js", "a\nb\n")
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "-qm", "base")
+	writeFile(t, "a.js", "a  \nb\n")
+	writeFile(t, "b.js", "a\nb  \n")
+	git(t, dir, "add", ".")
+
+	run := runPretti(t, dir, "--staged", "--check", "--check-only-changed-lines")
+	if run.code != 1 || !strings.Contains(run.stdout, "a.js") || !strings.Contains(run.stdout, "b.js") {
+		t.Errorf("pretti --staged --check-only-changed-lines exited %d, want 1 reporting a.js and b.js:\n%s%s", run.code, run.stdout, run.stderr)
+	}
+
+	// An unstaged line above the staged change shifts it in the working
+	// tree copy.
+	writeFile(t, "b.js", "new\na\nb  \n")
+	run = runPretti(t, dir, "--staged", "--check", "--check-only-changed-lines")
+	if run.code != 1 || !strings.Contains(run.stderr, "cannot check staged files that also have unstaged changes") || !strings.Contains(run.stderr, "b.js") {
+		t.Errorf("pretti --staged --check-only-changed-lines with a partially staged file exited %d, want it refused:\n%s%s", run.code, run.stdout, run.stderr)
+	}
+	if run.prettier != "" {
+		t.Errorf("prettier ran with %q, want it not run", run.prettier)
+	}
+}
+
+func TestProfileWrittenOnFatalError(t *testing.T) {
+	dir := gitRepo(t)
+	writeFile(t, "a.js", "a\n")
+	for _, args := range [][]string{
+		{"a.js"},
+		{"--root", filepath.Join(dir, "missing"), "a.js"},
+	} {
+		profile := filepath.Join(t.TempDir(), "cpu.pprof")
+		run := runPretti(t, dir, append([]string{"--profile", profile}, args...)...)
+		if info := stat(t, profile); info == nil || info.Size() == 0 {
+			t.Errorf("pretti %s exited %d and left the profile empty or missing:\n%s", strings.Join(args, " "), run.code, run.stderr)
+		}
+	}
+}
+
+func TestVersionSatisfies(t *testing.T) {
+	tests := []struct {
+		version, constraint string
+		want                bool
+	}{
+		{"3.3.3", "3.3.3", true},
+		{"3.3.4", "3.3.3", false},
+		{"v3.3.3", "=3.3.3", true},
+		{"3.3.3-beta", "3.3.3", false},
+		{"3.3.3-beta", "3.3.3-beta", true},
+		{"3.3.3-beta.2", "3.3.3-beta.1", false},
+		{"3.3.3+build.5", "3.3.3", true},
+		{"3.3.7", "3.3", true},
+		{"3.4.0", "3.3", false},
+		{"3.9.1", "3", true},
+		{"3.4.0-rc.1", "3.4", false},
+
+		{"3.3.0", "^3.3.0", true},
+		{"3.9.2", "^3.3.0", true},
+		{"4.0.0", "^3.3.0", false},
+		{"3.2.9", "^3.3.0", false},
+		{"0.2.5", "^0.2.3", true},
+		{"0.3.0", "^0.2.3", false},
+		{"0.0.3", "^0.0.3", true},
+		{"0.0.4", "^0.0.3", false},
+		{"0.0.9", "^0.0", true},
+		{"0.1.0", "^0.0", false},
+		{"0.9.0", "^0", true},
+		{"1.0.0", "^0", false},
+		{"3.9.0", "^3", true},
+		{"4.0.0-beta", "^3.3.0", false},
+		{"3.4.0-beta", "^3.3.0", false},
+		{"3.3.0-beta.2", "^3.3.0-beta.1", true},
+		{"3.3.0-beta.1", "^3.3.0-beta.2", false},
+		{"3.3.0-beta.11", "^3.3.0-beta.2", true},
+		{"3.3.0-beta", "^3.3.0-alpha", true},
+		{"3.3.0", "^3.3.0-beta", true},
+
+		{"3.3.9", "~3.3.0", true},
+		{"3.4.0", "~3.3.0", false},
+		{"3.3.1", "~3.3.2", false},
+		{"3.3.5", "~3.3", true},
+		{"3.4.0", "~3.3", false},
+		{"3.1.0", "~3", true},
+		{"3.9.9", "~3", true},
+		{"4.0.0", "~3", false},
+		{"0.0.5", "~0.0.3", true},
+		{"0.1.0", "~0.0.3", false},
+	}
+	for _, tt := range tests {
+		got, err := versionSatisfies(tt.version, tt.constraint)
+		if err != nil {
+			t.Errorf("versionSatisfies(%q, %q): %v", tt.version, tt.constraint, err)
+		} else if got != tt.want {
+			t.Errorf("versionSatisfies(%q, %q) = %v, want %v", tt.version, tt.constraint, got, tt.want)
+		}
+	}
+	for _, constraint := range []string{"", "^", "3.x", "1.2.3.4", ">=3"} {
+		if _, err := versionSatisfies("3.3.3", constraint); err == nil {
+			t.Errorf("versionSatisfies(3.3.3, %q) succeeded, want an error", constraint)
+		}
+	}
+}